@@ -0,0 +1,66 @@
+package mongoqs
+
+import (
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QIndexCandidate - Index keys that would support an observed combination of filters and sorts
+type QIndexCandidate struct {
+	Keys bson.D // Index keys ordered by equality filters, sorts, range filters, then 2dsphere keys of geospatial filters
+	Queries int64 // Number of queries that used the combination
+}
+
+// QIndexFinding - A field and operator combination that cannot use an index efficiently
+type QIndexFinding struct {
+	Field string // Field key
+	Operator string // Operator that was used
+	Queries int64 // Number of times the operator was used on the field
+	Message string // Why an index will not help
+}
+
+// QIndexReport - Index suggestions built from the usage statistics of a processor
+type QIndexReport struct {
+	Candidates []QIndexCandidate // Candidate indexes ordered by the number of queries that would use them
+	Findings []QIndexFinding // Filters that will never use an index efficiently
+}
+
+// IndexAdvice - Returns a QIndexReport built from the queries observed since TrackUsage was called. Candidate index keys follow the equality, sort, range rule. Fields filtered with near: or within: are given 2dsphere keys. Filters using like:, slike:, or elike: are reported since unanchored or case-insensitive regular expressions cannot use an index efficiently. The report is empty if usage is not being tracked.
+func (p *QProcessor) IndexAdvice() QIndexReport {
+	report := QIndexReport{Candidates: []QIndexCandidate{}, Findings: []QIndexFinding{}}
+	if p.usage == nil {
+		return report
+	}
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+	for _, c := range p.usage.shapes {
+		keys := make(bson.D, len(c.Keys))
+		copy(keys, c.Keys)
+		report.Candidates = append(report.Candidates, QIndexCandidate{Keys: keys, Queries: c.Queries})
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		if report.Candidates[i].Queries != report.Candidates[j].Queries {
+			return report.Candidates[i].Queries > report.Candidates[j].Queries
+		}
+		return len(report.Candidates[i].Keys) < len(report.Candidates[j].Keys)
+	})
+	for field, ops := range p.usage.fieldOps {
+		for _, op := range []string{like, elike} {
+			if n := ops[op]; n > 0 {
+				report.Findings = append(report.Findings, QIndexFinding{Field: field, Operator: op, Queries: n, Message: "unanchored regular expressions scan every index key or document"})
+			}
+		}
+		if n := ops[slike]; n > 0 {
+			// slike: is anchored but case-insensitive, so the prefix cannot bound the index scan
+			report.Findings = append(report.Findings, QIndexFinding{Field: field, Operator: slike, Queries: n, Message: "case-insensitive regular expressions scan every index key"})
+		}
+	}
+	sort.Slice(report.Findings, func(i, j int) bool {
+		if report.Findings[i].Queries != report.Findings[j].Queries {
+			return report.Findings[i].Queries > report.Findings[j].Queries
+		}
+		return report.Findings[i].Field+report.Findings[i].Operator < report.Findings[j].Field+report.Findings[j].Operator
+	})
+	return report
+}
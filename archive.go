@@ -0,0 +1,53 @@
+package mongoqs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// qarchive - Archive routing options
+type qarchive struct {
+	key string // Key of the QDateTime field whose filter decides the route
+	cutoff func() time.Time // Returns the time before which documents are archived
+}
+
+// WithArchiveRouting - Sets QResult TargetsArchive when the filter of the QDateTime field with the provided key can match documents older than the time returned by cutoff, so callers can direct old data queries to an archive cluster or Online Archive. A filter can match archived documents when it has no lower bound, like lt: or ne: alone, or when a gt:, gte:, eq:, in:, or all: value is before the cutoff. Queries that do not filter by the field are not routed to the archive. Exits if the key is not the Key of a QDateTime field of the processor. Returns caller for chaining.
+func (p *QProcessor) WithArchiveRouting(key string, cutoff func() time.Time) *QProcessor {
+	f := p.fieldByKey(key)
+	if f == nil {
+		log.Fatal(fmt.Sprintf("Archive routing field %q is not a field of the processor\n", key))
+	}
+	if f.Type != QDateTime {
+		log.Fatal(fmt.Sprintf("Archive routing field %q must be a QDateTime field\n", key))
+	}
+	p.archive = &qarchive{key: key, cutoff: cutoff}
+	return p
+}
+
+// targetsArchive - Returns true if the parsed clauses can match documents older than the cutoff
+func (a *qarchive) targetsArchive(clauses []QClause) bool {
+	if len(clauses) == 0 {
+		return false
+	}
+	cutoff := primitive.NewDateTimeFromTime(a.cutoff())
+	bounded := false
+	for _, c := range clauses {
+		switch c.Op {
+		case gt, gte, eq, in, all:
+			bounded = true
+			values := toList(c.Value)
+			if values == nil {
+				values = []interface{}{c.Value}
+			}
+			for _, v := range values {
+				if d, ok := v.(primitive.DateTime); ok && d < cutoff {
+					return true
+				}
+			}
+		}
+	}
+	return !bounded
+}
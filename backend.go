@@ -0,0 +1,116 @@
+package mongoqs
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QClause - A parsed filter condition of a field
+type QClause struct {
+	Op string // Operator, including the trailing :
+	Values []string // Raw values following the operator in the query
+	Value interface{} // Value parsed as the field's Type - list operators have a slice, and like:, slike:, and elike: have a case-insensitive regular expression
+}
+
+// QBackend - Converts parsed clauses to conditions for a query target, so the same parse can produce MongoDB filters, SQL WHERE fragments, or in-memory predicates
+type QBackend interface {
+	Condition(key string, clauses []QClause) (interface{}, error) // Returns the condition matching all of the clauses of the field with the provided key
+	And(conditions []interface{}) (interface{}, error) // Returns the condition matching all of the provided conditions
+}
+
+// MongoBackend - The reference QBackend that builds MongoDB filters. Conditions and their conjunction are bson.M values.
+type MongoBackend struct{}
+
+// Condition - Returns a bson.M with the provided key mapped to the MongoDB operators of the clauses. Conditions that span more than one document field, like versions stored as fields, are returned in an $and list. When more than one of like:, slike:, elike:, and re: is used, the first is mapped under the key and the others are added to the $and list, so documents must match every regular expression. Negated operators are wrapped in $not, and negations after the first are added to the $and list in the same way.
+func (MongoBackend) Condition(key string, clauses []QClause) (interface{}, error) {
+	ops := bson.M{}
+	and := bson.A{}
+	for _, c := range clauses {
+		switch v := c.Value.(type) {
+		case QVersion:
+			and = append(and, semverCondition(key, c.Op, v))
+			continue
+		case []QVersion:
+			and = append(and, semverListCondition(key, c.Op, v))
+			continue
+		}
+		if base, ok := negatedOp(c.Op); ok {
+			cond := bson.M{toMOp(base): c.Value}
+			if base == like || base == slike || base == elike {
+				cond = bson.M{"$regex": c.Value, "$options": "i"}
+			}
+			if _, ok := ops["$not"]; ok {
+				// $not can only appear once under the key, so later negations must also match through $and
+				and = append(and, bson.M{key: bson.M{"$not": cond}})
+				continue
+			}
+			ops["$not"] = cond
+			continue
+		}
+		switch c.Op {
+		case like, slike, elike:
+			if _, ok := ops["$regex"]; ok {
+				// an operator can only appear once under the key, so later regular expressions must also match through $and
+				and = append(and, bson.M{key: bson.M{"$regex": c.Value, "$options": "i"}})
+				continue
+			}
+			ops["$regex"] = c.Value
+			ops["$options"] = "i"
+		case re:
+			if _, ok := ops["$regex"]; ok {
+				and = append(and, bson.M{key: bson.M{"$regex": c.Value}})
+				continue
+			}
+			ops["$regex"] = c.Value
+		default:
+			ops[toMOp(c.Op)] = c.Value
+		}
+	}
+	cond := bson.M{}
+	if len(ops) > 0 {
+		cond[key] = ops
+	}
+	if len(and) > 0 {
+		// conditions on more than one document field cannot be nested under the key
+		cond["$and"] = and
+	}
+	return cond, nil
+}
+
+// And - Returns a bson.M with the keys of every condition, where $and lists are concatenated
+func (MongoBackend) And(conditions []interface{}) (interface{}, error) {
+	result := bson.M{}
+	for _, cond := range conditions {
+		for k, v := range cond.(bson.M) {
+			if and, ok := result["$and"].(bson.A); ok && k == "$and" {
+				result[k] = append(and, v.(bson.A)...)
+				continue
+			}
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+// setClauses - Records the parsed clauses of the field with the provided key
+func (r *QResult) setClauses(key string, clauses []QClause) {
+	if r.Parsed == nil {
+		r.Parsed = make(map[string][]QClause)
+	}
+	if _, ok := r.Parsed[key]; !ok {
+		r.clauseKeys = append(r.clauseKeys, key)
+	}
+	r.Parsed[key] = clauses
+}
+
+// Build - Converts the parsed field filters to a condition using the provided backend. Conditions added by visibility filters, templates, and or and nor groups are MongoDB specific and are not included.
+func (r *QResult) Build(b QBackend) (interface{}, error) {
+	conditions := make([]interface{}, 0, len(r.clauseKeys))
+	for _, key := range r.clauseKeys {
+		cond, err := b.Condition(key, r.Parsed[key])
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	return b.And(conditions)
+}
@@ -0,0 +1,62 @@
+package mongoqs
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QCache - Cache used by QExecutor for the results of Find and Count. Values are []bson.M documents with field decoders applied or int64 counts, and implementations that keep values in memory should store copies if callers may modify the documents they are given.
+type QCache interface {
+	Get(ctx context.Context, key string) (interface{}, bool)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration)
+}
+
+// qcache - Executor cache options
+type qcache struct {
+	cache QCache
+	collection string // Collection name used in cache keys
+	ttl time.Duration // How long values are cached
+}
+
+// WithCache - Caches the results of Find, Count, and List for the ttl, keyed by the collection name and the QResult Hash, for read heavy endpoints with repetitive queries. The collection name keeps results of different collections that share a cache apart - executors with a QCollectionResolver use the resolved name instead. Returns caller for chaining.
+func (e *QExecutor) WithCache(cache QCache, collection string, ttl time.Duration) *QExecutor {
+	e.cache = &qcache{cache: cache, collection: collection, ttl: ttl}
+	return e
+}
+
+// key - Returns the cache key of an operation on the QResult in the named collection, or in the cache's collection when name is empty
+func (c *qcache) key(name string, op string, r QResult) string {
+	if name == "" {
+		name = c.collection
+	}
+	return name + ":" + op + ":" + r.Hash()
+}
+
+// cachedDocs - Returns the cached documents of the QResult
+func (e *QExecutor) cachedDocs(ctx context.Context, name string, r QResult) ([]bson.M, bool) {
+	if e.cache == nil {
+		return nil, false
+	}
+	v, ok := e.cache.cache.Get(ctx, e.cache.key(name, "find", r))
+	docs, isDocs := v.([]bson.M)
+	return docs, ok && isDocs
+}
+
+// cachedCount - Returns the cached count of the QResult
+func (e *QExecutor) cachedCount(ctx context.Context, name string, r QResult) (int64, bool) {
+	if e.cache == nil {
+		return 0, false
+	}
+	v, ok := e.cache.cache.Get(ctx, e.cache.key(name, "count", r))
+	n, isCount := v.(int64)
+	return n, ok && isCount
+}
+
+// store - Caches the value of an operation on the QResult
+func (e *QExecutor) store(ctx context.Context, name string, op string, r QResult, value interface{}) {
+	if e.cache != nil {
+		e.cache.cache.Set(ctx, e.cache.key(name, op, r), value, e.cache.ttl)
+	}
+}
@@ -0,0 +1,84 @@
+package mongoqs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// canonical - Returns a copy of v where every map is converted to a bson.D with sorted keys so it always serializes the same way
+func canonical(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		return canonicalMap(t)
+	case map[string]interface{}:
+		return canonicalMap(t)
+	case map[string]string:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = v
+		}
+		return canonicalMap(m)
+	case bson.D:
+		d := make(bson.D, len(t))
+		for i, e := range t {
+			d[i] = bson.E{Key: e.Key, Value: canonical(e.Value)}
+		}
+		return d
+	case bson.A:
+		a := make(bson.A, len(t))
+		for i, e := range t {
+			a[i] = canonical(e)
+		}
+		return a
+	case []interface{}:
+		a := make(bson.A, len(t))
+		for i, e := range t {
+			a[i] = canonical(e)
+		}
+		return a
+	}
+	return v
+}
+
+// canonicalMap - Converts a map to a bson.D with sorted keys
+func canonicalMap(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := make(bson.D, len(keys))
+	for i, k := range keys {
+		d[i] = bson.E{Key: k, Value: canonical(m[k])}
+	}
+	return d
+}
+
+// canonicalJSON - Returns the relaxed extended JSON of v with map keys sorted
+func canonicalJSON(v interface{}) string {
+	b, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: canonical(v)}}, false, false)
+	if err != nil {
+		return err.Error()
+	}
+	// remove the {"v": and } wrapper needed to marshal values that are not documents
+	return string(b[len(`{"v":`) : len(b)-1])
+}
+
+// Hash - Returns a hex encoded SHA-256 hash of the canonical form of the QResult properties used to find documents - Filter, Projection, Sort, Limit, Skip, AddFields, Search, VectorSearch, and MinScore - so QResults that find the same documents have the same hash regardless of map ordering or how the query string was written.
+func (r *QResult) Hash() string {
+	sum := sha256.Sum256([]byte(canonicalJSON(bson.D{
+		{Key: "filter", Value: r.Filter},
+		{Key: "projection", Value: r.Projection},
+		{Key: "sort", Value: r.Sort},
+		{Key: "limit", Value: r.Limit},
+		{Key: "skip", Value: r.Skip},
+		{Key: "addFields", Value: r.AddFields},
+		{Key: "search", Value: r.Search},
+		{Key: "vectorSearch", Value: r.VectorSearch},
+		{Key: "minScore", Value: r.MinScore},
+	})))
+	return hex.EncodeToString(sum[:])
+}
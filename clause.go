@@ -0,0 +1,49 @@
+package mongoqs
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FormatClause - Returns a qvalue, using MongoQS syntax, for the provided operator and value. The operator may be provided with or without the trailing : (e.g. "gte" or "gte:"). Slices and arrays are formatted as comma separated lists, time.Time values are formatted as RFC3339, ObjectIDs are formatted as hex strings, and timestamps are formatted as seconds followed by the increment when it is not 0.
+func FormatClause(op string, value interface{}) string {
+	if !strings.HasSuffix(op, ":") {
+		op += ":"
+	}
+	return op + formatValue(value)
+}
+
+// formatValue - Formats a value as it would appear in a query string
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case primitive.DateTime:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Timestamp:
+		if v.I == 0 {
+			return strconv.FormatUint(uint64(v.T), 10)
+		}
+		return fmt.Sprintf("%d,%d", v.T, v.I)
+	case fmt.Stringer:
+		return v.String()
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		values := make([]string, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values[i] = formatValue(rv.Index(i).Interface())
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,74 @@
+package mongoqs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relative datetimes (QDateTime fields of processors with EnableRelativeDates)
+const relnow string = "now" // time the query is processed - may be followed by a signed offset like -24h or +7d
+var reldays *regexp.Regexp = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`) // day and week units, which time.ParseDuration does not support
+
+// DayRange - Returns the start of the day containing t and the start of the following day in the provided location. Boundaries are computed from calendar dates, so days that contain a DST transition are 23 or 25 hours long instead of being shifted by an hour.
+func DayRange(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc), time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
+
+// DateTimeRange - Returns a QDateTime qvalue, using MongoQS syntax, that matches datetimes greater than or equal to start and less than end.
+func DateTimeRange(start, end time.Time) string {
+	return fmt.Sprintf("%s%s,%s%s", gte, start.Format(time.RFC3339), lt, end.Format(time.RFC3339))
+}
+
+// DefaultToday - Returns a Default function for QDateTime fields that matches the current day in the provided location.
+func DefaultToday(loc *time.Location) func() string {
+	return DefaultLastDays(1, loc)
+}
+
+// DefaultLastDays - Returns a Default function for QDateTime fields that matches the current day and the n-1 days before it in the provided location. Each boundary is local midnight, including on days with DST transitions.
+func DefaultLastDays(n int, loc *time.Location) func() string {
+	return func() string {
+		start, end := DayRange(time.Now(), loc)
+		y, m, d := start.Date()
+		return DateTimeRange(time.Date(y, m, d-(n-1), 0, 0, 0, 0, start.Location()), end)
+	}
+}
+
+// parseRelativeTime - Parses now, or now followed by a signed offset, as an offset from the time the query is being processed. Offsets use Go duration syntax, like -1h30m, plus d for 24 hours and w for 7 days. Form encoding decodes + as a space, so a space before the offset is treated as +.
+func (f *QField) parseRelativeTime(v string) (time.Time, error) {
+	now := f.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	offset := strings.TrimPrefix(v, relnow)
+	if offset == "" {
+		return now, nil
+	}
+	if strings.HasPrefix(offset, " ") {
+		offset = "+" + offset[1:]
+	}
+	if offset[0] != '+' && offset[0] != '-' {
+		return time.Time{}, fmt.Errorf("%q is not a relative datetime - expected now followed by + or -", v)
+	}
+	offset = reldays.ReplaceAllStringFunc(offset, func(unit string) string {
+		m := reldays.FindStringSubmatch(unit)
+		n, _ := strconv.ParseFloat(m[1], 64) // the pattern only matches numbers
+		hours := 24.0
+		if m[2] == "w" {
+			hours = 24 * 7
+		}
+		return strconv.FormatFloat(n*hours, 'f', -1, 64) + "h"
+	})
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a relative datetime: %w", v, err)
+	}
+	return now.Add(d), nil
+}
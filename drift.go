@@ -0,0 +1,134 @@
+package mongoqs
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QDrift - Describes a field whose stored values do not have the BSON type its QType filters with, so its filters silently match fewer documents than expected
+type QDrift struct {
+	Key string // Key of the QField
+	Expected []string // BSON types the field's filters can match
+	Observed map[string]int // Map of the unexpected BSON types found to the number of values with that type
+	Checked int // Number of values checked
+}
+
+// DetectDrift - Finds up to sample documents in the collection and returns a QDrift for each filterable field with stored values of a BSON type its QType does not filter with, like a QInt field stored as strings. QCustom fields are not checked. Missing and null values are ignored, and each element of an array is checked. The collection decides which documents are found, so an implementation that uses $sample gives a better picture of large collections than the first documents in natural order.
+func (p *QProcessor) DetectDrift(ctx context.Context, coll QCollection, sample int64) ([]QDrift, error) {
+	r := NewQResult()
+	r.Limit = sample
+	docs, err := coll.Find(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	drifts := []QDrift{}
+	for _, field := range p.fields {
+		if field.IsMeta || field.IsNotFilterable || field.Type == QCustom {
+			// custom parsers can produce values of any type
+			continue
+		}
+		expected := expectedTypes(field)
+		drift := QDrift{Key: field.Key, Expected: expected, Observed: map[string]int{}}
+		for _, doc := range docs {
+			for _, v := range lookup(doc, strings.Split(field.dbKey(), ".")) {
+				name := bsonTypeName(v)
+				if name == "array" || name == "null" {
+					// array elements are checked individually
+					continue
+				}
+				drift.Checked++
+				if i := sort.SearchStrings(expected, name); i == len(expected) || expected[i] != name {
+					drift.Observed[name]++
+				}
+			}
+		}
+		if len(drift.Observed) > 0 {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, nil
+}
+
+// expectedTypes - Returns the sorted BSON type names the field's filters can match
+func expectedTypes(field QField) []string {
+	types := append([]QType{field.Type}, field.Coercion...)
+	set := map[string]bool{}
+	for _, t := range types {
+		names := []string{"string"}
+		switch t {
+		case QInt:
+			names = []string{"int", "long"}
+		case QFloat, QDecimal:
+			// numeric comparisons match across numeric types
+			names = []string{"double", "int", "long", "decimal"}
+		case QBool:
+			names = []string{"bool"}
+		case QDateTime:
+			names = []string{"date"}
+		case QObjectID:
+			names = []string{"objectId"}
+		case QIP:
+			if field.IPStorage == IPNumeric {
+				names = []string{"int", "long"}
+			}
+		case QSemver:
+			if field.SemverStorage == SemverFields {
+				names = []string{"object"}
+			}
+		case QTimestamp:
+			names = []string{"timestamp"}
+		case QGeo:
+			// GeoJSON objects or legacy coordinate pairs
+			names = []string{"array", "object"}
+		}
+		for _, name := range names {
+			set[name] = true
+		}
+	}
+	expected := []string{}
+	for name := range set {
+		expected = append(expected, name)
+	}
+	sort.Strings(expected)
+	return expected
+}
+
+// bsonTypeName - Returns the BSON type name of a decoded value, as used by the $type operator
+func bsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil, primitive.Null:
+		return "null"
+	case string:
+		return "string"
+	case int32:
+		return "int"
+	case int64, int:
+		return "long"
+	case float64, float32:
+		return "double"
+	case primitive.Decimal128:
+		return "decimal"
+	case bool:
+		return "bool"
+	case primitive.DateTime, time.Time:
+		return "date"
+	case primitive.ObjectID:
+		return "objectId"
+	case bson.M, bson.D, map[string]interface{}:
+		return "object"
+	case bson.A, []interface{}:
+		return "array"
+	case primitive.Regex:
+		return "regex"
+	case primitive.Binary:
+		return "binData"
+	case primitive.Timestamp:
+		return "timestamp"
+	}
+	return "unknown"
+}
@@ -0,0 +1,24 @@
+package mongoqs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ETag - Returns a quoted ETag derived from the QResult Hash and a data version token supplied by the caller, like a collection's last modified time or change stream resume token, so the ETag changes whenever the query or the data changes.
+func ETag(r QResult, version string) string {
+	sum := sha256.Sum256([]byte(r.Hash() + ":" + version))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// NotModified - Evaluates an If-None-Match header against the ETag and returns true if the endpoint can respond with 304 Not Modified. The header may list several ETags separated by commas or be *, and ETags are compared with the weak comparison required for If-None-Match.
+func NotModified(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package mongoqs
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QDecoder - Function that converts a document value to a client friendly value before it is returned by a QExecutor
+type QDecoder func(v interface{}) interface{}
+
+// DecodeDateTimeRFC3339 - QDecoder that converts BSON datetimes to RFC3339 strings.
+func DecodeDateTimeRFC3339(v interface{}) interface{} {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return time.Unix(0, int64(t)*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	return v
+}
+
+// DecodeDecimalString - QDecoder that converts BSON Decimal128 values to strings so no precision is lost in JSON.
+func DecodeDecimalString(v interface{}) interface{} {
+	if d, ok := v.(primitive.Decimal128); ok {
+		return d.String()
+	}
+	return v
+}
+
+// DecodeObjectIDHex - QDecoder that converts ObjectIDs to hex strings.
+func DecodeObjectIDHex(v interface{}) interface{} {
+	if id, ok := v.(primitive.ObjectID); ok {
+		return id.Hex()
+	}
+	return v
+}
+
+// QEnvelope - A page of documents and the paging information used to find them
+type QEnvelope struct {
+	Items []bson.M `json:"items"` // Documents with field decoders applied
+	Limit int64 `json:"limit"` // Limit used to find the documents
+	Skip int64 `json:"skip"` // Skip used to find the documents
+	Warnings []string `json:"warnings,omitempty"` // Warnings from processing the query
+}
+
+// QCollection - Collection operations used by QExecutor. Implementations should apply every QResult property (Filter, Projection, Sort, Limit, and Skip) when finding documents, and Filter, Limit, and Skip when counting them, and pass the context to the driver so sessions started by the caller are used. A small adapter around *mongo.Collection is shown in the README - keeping the driver behind this interface means MongoQS only depends on the driver's bson packages.
+type QCollection interface {
+	Find(ctx context.Context, r QResult) ([]bson.M, error)
+	Count(ctx context.Context, r QResult) (int64, error)
+}
+
+// QCollectionResolver - Function that returns the collection queries run against for the request context, like the collection of the caller's tenant, and a name identifying it, like "tenant42.items". The name is used in place of the WithCache collection name so cached results of different tenants are kept apart.
+type QCollectionResolver func(ctx context.Context) (QCollection, string, error)
+
+// QExecutor - Runs QResults against a collection and applies the processor's field decoders to the documents that are found. The context passed to each method, or a context derived from it when the retry policy has a Timeout, is passed to the collection, so queries run with a mongo.SessionContext take part in the caller's causally consistent session or transaction.
+type QExecutor struct {
+	processor *QProcessor
+	collection QCollection
+	resolver QCollectionResolver // Resolves the collection of each request - collection is used when nil
+	retry QRetryPolicy // How failed collection operations are retried
+	cache *qcache // Cache options - results are not cached when nil
+}
+
+// NewQExecutor - Returns a new QExecutor that processes queries with the provided processor and runs them against the provided collection.
+func NewQExecutor(p *QProcessor, collection QCollection) *QExecutor {
+	return &QExecutor{processor: p, collection: collection}
+}
+
+// NewQExecutorWithResolver - Returns a new QExecutor that processes queries with the provided processor and runs them against the collection returned by the resolver for each request's context, so tenant-per-collection and tenant-per-database layouts can share one executor.
+func NewQExecutorWithResolver(p *QProcessor, resolver QCollectionResolver) *QExecutor {
+	return &QExecutor{processor: p, resolver: resolver}
+}
+
+// resolve - Returns the collection for the context and the name used in its cache keys, which is empty when the executor has a fixed collection
+func (e *QExecutor) resolve(ctx context.Context) (QCollection, string, error) {
+	if e.resolver == nil {
+		return e.collection, "", nil
+	}
+	return e.resolver(ctx)
+}
+
+// Find - Finds the documents matching the QResult, using the context's session if it has one, and applies field decoders to them.
+func (e *QExecutor) Find(ctx context.Context, r QResult) ([]bson.M, error) {
+	coll, name, err := e.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if docs, ok := e.cachedDocs(ctx, name, r); ok {
+		return docs, nil
+	}
+	var docs []bson.M
+	err = e.attempt(ctx, func(ctx context.Context) (err error) {
+		docs, err = coll.Find(ctx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		e.decode(doc)
+	}
+	e.store(ctx, name, "find", r, docs)
+	return docs, nil
+}
+
+// Count - Counts the documents matching the QResult, using the context's session if it has one. Filters using near: are counted with the equivalent $geoWithin conditions since MongoDB does not allow $near when counting.
+func (e *QExecutor) Count(ctx context.Context, r QResult) (int64, error) {
+	coll, name, err := e.resolve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r.Filter = countFilter(r.Filter)
+	if n, ok := e.cachedCount(ctx, name, r); ok {
+		return n, nil
+	}
+	var n int64
+	err = e.attempt(ctx, func(ctx context.Context) (err error) {
+		n, err = coll.Count(ctx, r)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	e.store(ctx, name, "count", r, n)
+	return n, nil
+}
+
+// List - Processes the query and returns the matching documents in a QEnvelope, ready to be encoded as the response of a list endpoint.
+func (e *QExecutor) List(ctx context.Context, query url.Values) (QEnvelope, error) {
+	r, err := e.processor.ProcessContext(ctx, query)
+	if err != nil {
+		return QEnvelope{}, err
+	}
+	docs, err := e.Find(ctx, r)
+	if err != nil {
+		return QEnvelope{}, err
+	}
+	envelope := QEnvelope{Items: docs, Limit: r.Limit, Skip: r.Skip}
+	for _, w := range r.Warnings {
+		envelope.Warnings = append(envelope.Warnings, w.String())
+	}
+	return envelope, nil
+}
+
+// decode - Applies each field's decoder to the document in place
+func (e *QExecutor) decode(doc bson.M) {
+	for _, field := range e.processor.fields {
+		if field.Decoder == nil {
+			continue
+		}
+		decodePath(doc, strings.Split(field.dbKey(), "."), field.Decoder)
+	}
+}
+
+// decodePath - Applies the decoder to the value at the dot notation path. Arrays are traversed so each element is decoded.
+func decodePath(v interface{}, path []string, decoder QDecoder) interface{} {
+	switch t := v.(type) {
+	case bson.A:
+		for i, child := range t {
+			t[i] = decodePath(child, path, decoder)
+		}
+		return t
+	case bson.M:
+		if len(path) > 0 {
+			if child, ok := t[path[0]]; ok {
+				t[path[0]] = decodePath(child, path[1:], decoder)
+			}
+			return t
+		}
+	}
+	if len(path) == 0 {
+		return decoder(v)
+	}
+	return v
+}
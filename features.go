@@ -0,0 +1,33 @@
+package mongoqs
+
+import (
+	"net/url"
+	"strings"
+)
+
+// QFeatures - Grammar capabilities a processor opts into. Every feature is disabled by default, so processors keep parsing query strings exactly as they did before a feature was added, and teams can adopt features endpoint by endpoint.
+type QFeatures struct {
+	EnableKeywords bool // If true, null after eq: or ne:, or as the implied eq: value, matches fields that are null or missing
+	EnableBrackets bool // If true, repeated bracketed keys (key[]=a&key[]=b), as sent by PHP and axios style clients, are the values of an in: list
+	EnableRelativeDates bool // If true, QDateTime values can be now, or now followed by a signed offset like now-24h or now+7d, evaluated when the query is processed
+}
+
+// WithFeatures - Sets the grammar features the processor uses. Returns caller for chaining.
+func (p *QProcessor) WithFeatures(features QFeatures) *QProcessor {
+	p.features = features
+	return p
+}
+
+// bracketValue - Returns an in: clause of the non-empty values of the bracketed key (key[]), or an empty string if there are none
+func bracketValue(query url.Values, key string) string {
+	values := []string{}
+	for _, v := range query[key+"[]"] {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return in + strings.Join(values, ",")
+}
@@ -0,0 +1,133 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// qcollection - A collection the processor's queries are fanned out to
+type qcollection struct {
+	name string // Collection name
+	keys map[string]bool // Keys of the fields available in the collection
+}
+
+// QFederatedResult - The QResults of one query for each collection bound to a processor
+type QFederatedResult struct {
+	Collections []string // Names of the collections in Results, in the order they were bound
+	Results map[string]QResult // Map of collection names to the QResult for the collection
+	Skipped map[string][]string // Map of the names of collections that were left out to the keys of the filtered fields they do not have
+}
+
+// WithCollection - Binds the processor to a collection that has the fields with the provided keys, so ProcessFederated returns a QResult for it. Fields that are not available in a collection are left out of its QResult. Exits if a key is not the Key of one of the processor's fields. Returns caller for chaining.
+func (p *QProcessor) WithCollection(name string, keys ...string) *QProcessor {
+	if name == "" {
+		log.Fatal("Collection name cannot be an empty string")
+	}
+	c := qcollection{name: name, keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		found := false
+		for _, f := range p.fields {
+			found = found || f.Key == key
+		}
+		if !found {
+			log.Fatal(fmt.Sprintf("Collection %q field %q is not a field of the processor\n", name, key))
+		}
+		c.keys[key] = true
+	}
+	p.collections = append(p.collections, c)
+	return p
+}
+
+// ProcessFederated - Converts the provided URL query to a QResult for each collection bound with WithCollection, using only the fields available in the collection. Collections that do not have a field the client filtered by cannot match the query, so they are left out of the Results and listed in Skipped. Sorts and projections of unavailable fields are ignored like any other unknown key, and Default functions of unavailable fields are not used.
+func (p *QProcessor) ProcessFederated(ctx context.Context, query url.Values) (QFederatedResult, error) {
+	federated := QFederatedResult{Collections: []string{}, Results: map[string]QResult{}, Skipped: map[string][]string{}}
+	recorded := false // true once the usage of the query has been recorded
+	for _, c := range p.collections {
+		sub := *p
+		sub.collections = nil
+		// keys of fields the collection does not have are declared by the processor
+		sub.learner = nil
+		if recorded {
+			// the request is counted once, by the first collection it is processed for
+			sub.usage = nil
+		}
+		sub.fields = []QField{}
+		missing := []string{}
+		for _, f := range p.fields {
+			if c.keys[f.Key] {
+				sub.fields = append(sub.fields, f)
+			} else if !f.IsMeta && !f.IsNotFilterable && hasQueryValue(f, query, p.features.EnableBrackets) {
+				missing = append(missing, f.Key)
+			}
+		}
+		if len(missing) > 0 {
+			federated.Skipped[c.name] = missing
+			continue
+		}
+		result, err := sub.ProcessContext(ctx, query)
+		if err != nil {
+			return QFederatedResult{}, fmt.Errorf("collection %q: %w", c.name, err)
+		}
+		federated.Collections = append(federated.Collections, c.name)
+		federated.Results[c.name] = result
+		recorded = true
+	}
+	if p.usage != nil && !recorded {
+		// every collection was skipped, so the request is counted without fields
+		p.usage.record(nil, nil, nil, false)
+	}
+	if p.learner != nil {
+		p.learner.record(p, query)
+	}
+	return federated, nil
+}
+
+// hasQueryValue - Returns true if the query has a value for the field's key or one of its aliases, including their bracketed keys when brackets is true
+func hasQueryValue(field QField, query url.Values, brackets bool) bool {
+	for _, key := range append([]string{field.Key}, field.Aliases...) {
+		if query.Get(key) != "" || (brackets && bracketValue(query, key) != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline - Returns the name of the first collection in Results and the aggregation pipeline stages to run on it that combine the documents of every collection in Results with $unionWith stages, for split collections like live and archived documents. Each collection's filter is applied in its own branch, and the Sort, Skip, Limit, and Projection of the first collection's QResult are applied to the combined documents. When there is a Limit, each branch is also sorted and limited to Skip plus Limit documents so no more documents than needed are combined. Returns an empty name when there are no Results.
+func (f QFederatedResult) Pipeline() (string, []bson.M) {
+	if len(f.Collections) == 0 {
+		return "", []bson.M{}
+	}
+	first := f.Results[f.Collections[0]]
+	branch := func(r QResult) []bson.M {
+		stages := r.filterStages()
+		if first.Limit > 0 {
+			if len(first.Sort) > 0 {
+				stages = append(stages, bson.M{"$sort": first.Sort})
+			}
+			stages = append(stages, bson.M{"$limit": first.Skip + first.Limit})
+		}
+		return stages
+	}
+	pipeline := branch(first)
+	for _, name := range f.Collections[1:] {
+		r := f.Results[name]
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{"coll": name, "pipeline": branch(r)}})
+	}
+	if len(first.Sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": first.Sort})
+	}
+	if first.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": first.Skip})
+	}
+	if first.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": first.Limit})
+	}
+	if len(first.Projection) > 0 {
+		pipeline = append(pipeline, bson.M{"$project": first.Projection})
+	}
+	return f.Collections[0], pipeline
+}
@@ -0,0 +1,103 @@
+package mongoqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QFormat - How a QResult is rendered by Render
+type QFormat int
+// FormatText - Multi-line sections for reading in a terminal. Used by String.
+const FormatText QFormat = 0
+// FormatCompact - A single line of key=value pairs with JSON values, for log lines
+const FormatCompact QFormat = 1
+// FormatJSON - Indented relaxed extended JSON, for debug endpoints
+const FormatJSON QFormat = 2
+// FormatExtJSON - Single line canonical extended JSON, which keeps BSON types like int64 and dates so the output can be parsed back into the same values
+const FormatExtJSON QFormat = 3
+
+// Render - Returns the Filter, Projection, Sort, paging, Meta, and Warnings of the QResult in the provided format, with the values of PII fields masked when IsRedacted is set. Maps are rendered with sorted keys so the same QResult is always rendered the same way in the JSON based formats.
+func (r *QResult) Render(format QFormat) string {
+	if r.IsRedacted {
+		c := r.Redacted()
+		r = &c
+	}
+	switch format {
+	case FormatCompact:
+		return fmt.Sprintf("filter=%s projection=%s sort=%s limit=%d skip=%d meta=%s warnings=%s",
+			canonicalJSON(r.Filter), canonicalJSON(r.Projection), canonicalJSON(r.Sort), r.Limit, r.Skip, canonicalJSON(r.Meta), canonicalJSON(r.warningList()))
+	case FormatJSON:
+		var b bytes.Buffer
+		if err := json.Indent(&b, []byte(r.extJSON(false)), "", "  "); err != nil {
+			return err.Error()
+		}
+		return b.String()
+	case FormatExtJSON:
+		return r.extJSON(true)
+	}
+	return fmt.Sprintf(`
+	----- Filter -----
+	%v
+	------------------
+	--- Projection ---
+	%v
+	------------------
+	------ Sort ------
+	%v
+	------------------
+	----- Paging -----
+	Limit:  %d
+	Skip:   %d
+	------------------
+	------ Meta ------
+	%v
+	------------------
+	---- Warnings ----
+	%v
+	------------------
+	` , r.Filter, r.Projection, r.Sort, r.Limit, r.Skip, r.Meta, r.Warnings)
+}
+
+// warningList - Returns the Warnings as strings
+func (r *QResult) warningList() bson.A {
+	warnings := bson.A{}
+	for _, w := range r.Warnings {
+		warnings = append(warnings, w.String())
+	}
+	return warnings
+}
+
+// extJSON - Returns the rendered properties of the QResult as canonical or relaxed extended JSON
+func (r *QResult) extJSON(canonicalTypes bool) string {
+	doc := bson.D{
+		{Key: "filter", Value: canonical(r.Filter)},
+		{Key: "projection", Value: canonical(r.Projection)},
+		{Key: "sort", Value: canonical(r.Sort)},
+		{Key: "limit", Value: r.Limit},
+		{Key: "skip", Value: r.Skip},
+		{Key: "meta", Value: canonical(r.Meta)},
+		{Key: "warnings", Value: r.warningList()},
+	}
+	b, err := bson.MarshalExtJSON(doc, canonicalTypes, false)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// Format - Implements fmt.Formatter. %v and %s use FormatText, %+v uses FormatCompact, and %#v uses FormatExtJSON.
+func (r *QResult) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprint(f, r.Render(FormatCompact))
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprint(f, r.Render(FormatExtJSON))
+	case verb == 'v' || verb == 's':
+		fmt.Fprint(f, r.Render(FormatText))
+	default:
+		fmt.Fprintf(f, "%%!%c(*mongoqs.QResult)", verb)
+	}
+}
@@ -0,0 +1,107 @@
+package mongoqs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrFragmentNotValid - Returned, wrapped with details, when a filter fragment uses an operator outside the allowlist or is nested too deeply.
+var ErrFragmentNotValid = errors.New("filter fragment not valid")
+
+// ErrFilterNotSafe - Returned, wrapped with details, by Sanitize and the processor when an outgoing filter uses an operator outside the allowlist or is nested too deeply.
+var ErrFilterNotSafe = errors.New("filter not safe")
+
+// maxFragmentDepth - Maximum nesting depth of documents and arrays in a filter fragment
+const maxFragmentDepth int = 10
+
+// fragmentOps - Query operators allowed in filter fragments and outgoing filters. Operators that run code on the server, like $where and $function, and operators that can read other documents are never allowed.
+var fragmentOps map[string]bool = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$all": true, "$exists": true, "$type": true, "$size": true, "$mod": true,
+	"$regex": true, "$options": true, "$elemMatch": true, "$not": true,
+	"$and": true, "$or": true, "$nor": true,
+	"$bitsAllSet": true, "$bitsAnySet": true, "$bitsAllClear": true, "$bitsAnyClear": true,
+	"$near": true, "$nearSphere": true, "$geoWithin": true, "$geoIntersects": true, "$geometry": true, "$maxDistance": true, "$minDistance": true,
+	"$box": true, "$polygon": true, "$center": true, "$centerSphere": true,
+}
+
+// validateFragment - Returns an error if the value contains an operator outside the allowlist or is nested deeper than maxFragmentDepth
+func validateFragment(v interface{}, depth int) error {
+	if depth > maxFragmentDepth {
+		return fmt.Errorf("nested deeper than %d levels", maxFragmentDepth)
+	}
+	check := func(key string, child interface{}) error {
+		if len(key) > 0 && key[0] == '$' && !fragmentOps[key] {
+			return fmt.Errorf("operator %q is not allowed", key)
+		}
+		return validateFragment(child, depth+1)
+	}
+	switch t := v.(type) {
+	case bson.M:
+		for k, child := range t {
+			if err := check(k, child); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for k, child := range t {
+			if err := check(k, child); err != nil {
+				return err
+			}
+		}
+	case bson.D:
+		for _, e := range t {
+			if err := check(e.Key, e.Value); err != nil {
+				return err
+			}
+		}
+	case bson.A, []interface{}:
+		for _, child := range toList(t) {
+			if err := validateFragment(child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Attach - Adds a hand-written filter fragment to the Filter with $and under a named slot, so handler conditions and parsed conditions are merged the same way every time. Attaching to a slot that already has a fragment replaces it. Returns an error wrapping ErrFragmentNotValid, without changing the Filter, if the fragment uses an operator outside the allowlist, like $where or $expr, or is nested more than 10 levels deep.
+func (r *QResult) Attach(slot string, fragment bson.M) error {
+	if err := validateFragment(fragment, 0); err != nil {
+		return fmt.Errorf("%w: slot %q: %v", ErrFragmentNotValid, slot, err)
+	}
+	if previous, ok := r.slots[slot]; ok {
+		// remove the previous fragment of the slot from the $and list
+		conds, _ := r.Filter["$and"].(bson.A)
+		kept := bson.A{}
+		for _, cond := range conds {
+			if m, ok := cond.(bson.M); !ok || reflect.ValueOf(m).Pointer() != reflect.ValueOf(previous).Pointer() {
+				kept = append(kept, cond)
+			}
+		}
+		if len(kept) > 0 {
+			r.Filter["$and"] = kept
+		} else {
+			delete(r.Filter, "$and")
+		}
+	}
+	if r.slots == nil {
+		r.slots = make(map[string]bson.M)
+	}
+	r.slots[slot] = fragment
+	if len(fragment) > 0 {
+		r.and(fragment)
+	}
+	return nil
+}
+
+// Sanitize - Returns an error wrapping ErrFilterNotSafe if the filter uses an operator outside the allowlist used by Attach, like $where or $function, or is nested more than 10 levels deep. The processor sanitizes every Filter it builds, including conditions from templates and visibility filters, so this is only needed for filters changed after processing by other means than Attach.
+func Sanitize(filter bson.M) error {
+	if err := validateFragment(filter, 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrFilterNotSafe, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package mongoqs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// earthRadius - Equatorial radius of the earth in meters, used to convert circle radiuses to the radians expected by $centerSphere
+const earthRadius float64 = 6378100
+
+// geo shapes used with within:
+const geopoly string = "poly" // polygon - poly(<lng>,<lat>,<lng>,<lat>,<lng>,<lat>,...)
+const geobox string = "box" // rectangle - box(<lng>,<lat>,<lng>,<lat>) with opposite corners
+const geocircle string = "circle" // circle on a sphere - circle(<lng>,<lat>,<meters>)
+
+// parseCoords - Parses each value as a finite float
+func parseCoords(values []string) ([]float64, error) {
+	coords := make([]float64, len(values))
+	for i, v := range values {
+		c, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsNaN(c) || math.IsInf(c, 0) {
+			return nil, fmt.Errorf("%q is not a coordinate", v)
+		}
+		coords[i] = c
+	}
+	return coords, nil
+}
+
+// geoPosition - Returns a GeoJSON position, or an error if the longitude or latitude is out of range
+func geoPosition(lng float64, lat float64) (bson.A, error) {
+	if lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+		return nil, fmt.Errorf("%v,%v is not a longitude and latitude", lng, lat)
+	}
+	return bson.A{lng, lat}, nil
+}
+
+// parseNear - Parses the values of near: - a longitude, a latitude, and an optional maximum and minimum distance in meters - into a $near operand with a GeoJSON point
+func parseNear(values []string) (bson.M, error) {
+	if len(values) < 2 || len(values) > 4 {
+		return nil, fmt.Errorf("near: expects a longitude, a latitude, and optional maximum and minimum distances - got %d values", len(values))
+	}
+	coords, err := parseCoords(values)
+	if err != nil {
+		return nil, err
+	}
+	position, err := geoPosition(coords[0], coords[1])
+	if err != nil {
+		return nil, err
+	}
+	near := bson.M{"$geometry": bson.M{"type": "Point", "coordinates": position}}
+	for i, op := range []string{"$maxDistance", "$minDistance"} {
+		if len(coords) <= i+2 {
+			break
+		}
+		if coords[i+2] < 0 {
+			return nil, fmt.Errorf("%v is not a distance", coords[i+2])
+		}
+		near[op] = coords[i+2]
+	}
+	if max, ok := near["$maxDistance"].(float64); ok {
+		if min, ok := near["$minDistance"].(float64); ok && min > max {
+			return nil, fmt.Errorf("minimum distance %v is greater than maximum distance %v", min, max)
+		}
+	}
+	return near, nil
+}
+
+// parseWithin - Parses the value of within: - a poly, box, or circle shape - into a $geoWithin operand. Polygons and boxes are GeoJSON polygons and circles use $centerSphere.
+func parseWithin(value string) (bson.M, error) {
+	open := strings.Index(value, "(")
+	if open < 0 || !strings.HasSuffix(value, ")") {
+		return nil, fmt.Errorf("%q is not a shape", value)
+	}
+	shape := value[:open]
+	coords, err := parseCoords(strings.Split(value[open+1:len(value)-1], ","))
+	if err != nil {
+		return nil, err
+	}
+	switch shape {
+	case geopoly:
+		if len(coords) < 6 || len(coords)%2 != 0 {
+			return nil, fmt.Errorf("%s expects at least 3 longitude and latitude pairs", geopoly)
+		}
+		ring := bson.A{}
+		for i := 0; i < len(coords); i += 2 {
+			position, err := geoPosition(coords[i], coords[i+1])
+			if err != nil {
+				return nil, err
+			}
+			ring = append(ring, position)
+		}
+		if first, last := ring[0].(bson.A), ring[len(ring)-1].(bson.A); first[0] != last[0] || first[1] != last[1] {
+			// GeoJSON rings must end where they start
+			ring = append(ring, first)
+		}
+		return bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{ring}}}, nil
+	case geobox:
+		if len(coords) != 4 {
+			return nil, fmt.Errorf("%s expects 2 longitude and latitude pairs", geobox)
+		}
+		ring := bson.A{}
+		for _, corner := range [][2]float64{{coords[0], coords[1]}, {coords[2], coords[1]}, {coords[2], coords[3]}, {coords[0], coords[3]}, {coords[0], coords[1]}} {
+			position, err := geoPosition(corner[0], corner[1])
+			if err != nil {
+				return nil, err
+			}
+			ring = append(ring, position)
+		}
+		return bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": bson.A{ring}}}, nil
+	case geocircle:
+		if len(coords) != 3 {
+			return nil, fmt.Errorf("%s expects a longitude, a latitude, and a radius", geocircle)
+		}
+		center, err := geoPosition(coords[0], coords[1])
+		if err != nil {
+			return nil, err
+		}
+		if coords[2] < 0 {
+			return nil, fmt.Errorf("%v is not a radius", coords[2])
+		}
+		return bson.M{"$centerSphere": bson.A{center, coords[2] / earthRadius}}, nil
+	}
+	return nil, fmt.Errorf("%q is not a shape - expected %s, %s, or %s", shape, geopoly, geobox, geocircle)
+}
+
+// countFilter - Returns a copy of the filter where $near conditions, which MongoDB does not allow when counting documents, are replaced by $geoWithin conditions matching the same documents - a $centerSphere of the maximum distance, excluding a $centerSphere of the minimum distance
+func countFilter(filter bson.M) bson.M {
+	result := make(bson.M, len(filter))
+	for key, v := range filter {
+		switch t := v.(type) {
+		case bson.A:
+			if key == "$and" {
+				list := make(bson.A, len(t))
+				for i, cond := range t {
+					if m, ok := cond.(bson.M); ok {
+						cond = countFilter(m)
+					}
+					list[i] = cond
+				}
+				result[key] = list
+				continue
+			}
+		case bson.M:
+			if near, ok := t["$near"].(bson.M); ok {
+				if within, ok := nearWithin(near); ok {
+					ops := make(bson.M, len(t))
+					for op, operand := range t {
+						if op != "$near" {
+							ops[op] = operand
+						}
+					}
+					for op, operand := range within {
+						ops[op] = operand
+					}
+					result[key] = ops
+					continue
+				}
+			}
+		}
+		result[key] = v
+	}
+	return result
+}
+
+// nearWithin - Returns the operators matching the documents of a $near operand without sorting them by distance, and false if the operand does not have a GeoJSON point
+func nearWithin(near bson.M) (bson.M, bool) {
+	point, ok := near["$geometry"].(bson.M)
+	if !ok {
+		return nil, false
+	}
+	center, ok := point["coordinates"].(bson.A)
+	if !ok {
+		return nil, false
+	}
+	// without a maximum distance every document with a location is near the point
+	ops := bson.M{"$exists": true}
+	if max, ok := near["$maxDistance"].(float64); ok {
+		ops = bson.M{"$geoWithin": bson.M{"$centerSphere": bson.A{center, max / earthRadius}}}
+	}
+	if min, ok := near["$minDistance"].(float64); ok && min > 0 {
+		ops["$not"] = bson.M{"$geoWithin": bson.M{"$centerSphere": bson.A{center, min / earthRadius}}}
+	}
+	return ops, true
+}
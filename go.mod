@@ -1,5 +1,7 @@
 module github.com/rledford/mongoqs
 
-go 1.16
+go 1.18
 
 require go.mongodb.org/mongo-driver v1.5.0
+
+require github.com/go-stack/stack v1.8.0 // indirect
@@ -0,0 +1,66 @@
+package mongoqs
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Golden - Processes each sample query string and returns the results serialized deterministically so they can be compared with CompareGolden. Map keys are sorted and values are written as relaxed extended JSON, so the output only changes when the processor's behavior changes.
+func Golden(p *QProcessor, queries ...string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, qstring := range queries {
+		query, err := url.ParseQuery(strings.TrimPrefix(qstring, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("golden query %q is invalid: %w", qstring, err)
+		}
+		fmt.Fprintf(&b, "### %s\n", qstring)
+		result, err := p.Process(query)
+		if err != nil {
+			fmt.Fprintf(&b, "Error: %v\n\n", err)
+			continue
+		}
+		fmt.Fprintf(&b, "Filter: %s\n", canonicalJSON(result.Filter))
+		fmt.Fprintf(&b, "Projection: %s\n", canonicalJSON(result.Projection))
+		fmt.Fprintf(&b, "Sort: %s\n", canonicalJSON(result.Sort))
+		fmt.Fprintf(&b, "Limit: %d\n", result.Limit)
+		fmt.Fprintf(&b, "Skip: %d\n", result.Skip)
+		fmt.Fprintf(&b, "Meta: %s\n", canonicalJSON(result.Meta))
+		for _, w := range result.Warnings {
+			fmt.Fprintf(&b, "Warning: %s\n", w)
+		}
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
+
+// CompareGolden - Compares the output of Golden with the golden file at path. When update is true the golden file is written instead, which is how golden files are created and intentionally changed. Returns an error describing the first difference if the output does not match.
+func CompareGolden(path string, got []byte, update bool) error {
+	if update {
+		return os.WriteFile(path, got, 0644)
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("golden file %q could not be read - run with update enabled to create it: %w", path, err)
+	}
+	if bytes.Equal(want, got) {
+		return nil
+	}
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Errorf("golden file %q differs at line %d\nwant: %s\ngot:  %s", path, i+1, w, g)
+		}
+	}
+	return fmt.Errorf("golden file %q differs", path)
+}
@@ -0,0 +1,133 @@
+// Package grammar parses the mongoqs query value syntax, <operator>:<value>,<value>, into an AST without depending on MongoDB types, so validators, linters, and client generators can share the parser used by mongoqs.
+package grammar
+
+import (
+	"strings"
+)
+
+// Eq - The operator assumed for values that are not preceded by an operator
+const Eq string = "eq:"
+
+// Negations - Prefixes that negate the operator that follows them, like not:like: or !like:
+var Negations []string = []string{"not:", "!"}
+
+// Operators - Value operators recognized by the latest mongoqs syntax, followed by the negated operators
+var Operators []string = append([]string{"eq:", "ne:", "gt:", "gte:", "lt:", "lte:", "in:", "nin:", "all:", "like:", "slike:", "elike:", "bitsallset:", "bitsanyset:", "bitsallclear:", "emptyarray:", "exists:", "between:", "re:", "near:", "within:"}, Negate("eq:", "gt:", "gte:", "lt:", "lte:", "in:", "all:", "like:", "slike:", "elike:", "bitsallset:", "bitsanyset:", "bitsallclear:")...)
+
+// Negate - Returns each of the provided operators prefixed with each of the Negations
+func Negate(ops ...string) []string {
+	negated := make([]string, 0, len(ops)*len(Negations))
+	for _, op := range ops {
+		for _, n := range Negations {
+			negated = append(negated, n+op)
+		}
+	}
+	return negated
+}
+
+// Clause - An operator and the values that follow it
+type Clause struct {
+	Op string // Operator, including the trailing :
+	IsImplied bool // If true, the qvalue did not include the operator and Eq was assumed
+	Values []string // Values following the operator, split at ,
+	Start int // Byte offset of the clause in the qvalue
+	End int // Byte offset of the end of the clause in the qvalue
+}
+
+// Expr - A parsed qvalue
+type Expr struct {
+	Raw string // The qvalue the expression was parsed from
+	Clauses []Clause // Clauses in the order they appear in the qvalue
+}
+
+// Map - Returns a map of operators to the values of every clause using the operator
+func (e Expr) Map() map[string][]string {
+	result := make(map[string][]string, len(e.Clauses))
+	for _, c := range e.Clauses {
+		result[c.Op] = append(result[c.Op], c.Values...)
+	}
+	return result
+}
+
+// opAt - Returns the longest of the provided operators that starts at index i of the qvalue, or an empty string if there is none
+func opAt(qvalue string, i int, ops []string) string {
+	match := ""
+	for _, o := range ops {
+		if len(o) > len(match) && strings.HasPrefix(qvalue[i:], o) {
+			match = o
+		}
+	}
+	return match
+}
+
+// FindOps - Returns the start and end index of each of the provided operators in the qvalue. Operators are only detected at the beginning of the qvalue or after a , so values that contain an operator, like in: in domain:example, are not split.
+func FindOps(qvalue string, ops []string) [][]int {
+	indexes := [][]int{}
+	for i := 0; i < len(qvalue); i++ {
+		if i > 0 && qvalue[i-1] != ',' {
+			continue
+		}
+		if op := opAt(qvalue, i, ops); op != "" {
+			indexes = append(indexes, []int{i, i + len(op)})
+			i += len(op) - 1
+		}
+	}
+	return indexes
+}
+
+// FindOpsAnywhere - Returns the start and end index of each of the provided operators anywhere in the qvalue, the way operators were detected before they were limited to delimiter boundaries, so values that contain an operator, like in: in domain:example, are split.
+func FindOpsAnywhere(qvalue string, ops []string) [][]int {
+	indexes := [][]int{}
+	for i := 0; i < len(qvalue); i++ {
+		if op := opAt(qvalue, i, ops); op != "" {
+			indexes = append(indexes, []int{i, i + len(op)})
+			i += len(op) - 1
+		}
+	}
+	return indexes
+}
+
+// split - Splits the values of a clause at , ignoring a trailing ,
+func split(values string) []string {
+	return strings.Split(strings.TrimSuffix(values, ","), ",")
+}
+
+// Parse - Parses the qvalue into an expression using the provided operators, or Operators if none are provided. Operators are detected with FindOps. Values before the first operator, or the entire qvalue when there are no operators, are assumed to use Eq.
+func Parse(qvalue string, ops ...string) Expr {
+	if len(ops) == 0 {
+		ops = Operators
+	}
+	return toExpr(qvalue, FindOps(qvalue, ops))
+}
+
+// ParseLegacy - Parses the qvalue like Parse, but detects operators anywhere in the qvalue with FindOpsAnywhere, like mongoqs processors pinned to a syntax version before SyntaxV10
+func ParseLegacy(qvalue string, ops ...string) Expr {
+	if len(ops) == 0 {
+		ops = Operators
+	}
+	return toExpr(qvalue, FindOpsAnywhere(qvalue, ops))
+}
+
+// toExpr - Builds the expression of the qvalue from the start and end index of each operator
+func toExpr(qvalue string, opindexes [][]int) Expr {
+	expr := Expr{Raw: qvalue}
+	if len(opindexes) == 0 {
+		// no operators found, assuming eq: for entire qvalue
+		expr.Clauses = []Clause{{Op: Eq, IsImplied: true, Values: split(qvalue), Start: 0, End: len(qvalue)}}
+		return expr
+	}
+	expr.Clauses = make([]Clause, 0, len(opindexes)+1)
+	if opindexes[0][0] > 0 {
+		// operator not found at beginning of qvalue, assuming eq: up to first found operator
+		expr.Clauses = append(expr.Clauses, Clause{Op: Eq, IsImplied: true, Values: split(qvalue[0:opindexes[0][0]]), Start: 0, End: opindexes[0][0]})
+	}
+	for i, oi := range opindexes {
+		// values run from the end of the operator to the beginning of the next operator, or the end of the qvalue
+		end := len(qvalue)
+		if i+1 < len(opindexes) {
+			end = opindexes[i+1][0]
+		}
+		expr.Clauses = append(expr.Clauses, Clause{Op: qvalue[oi[0]:oi[1]], Values: split(qvalue[oi[1]:end]), Start: oi[0], End: end})
+	}
+	return expr
+}
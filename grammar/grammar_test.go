@@ -0,0 +1,28 @@
+package grammar
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	expr := Parse("a,b,gt:1,lt:5,in:1,2,3,")
+	want := []Clause{
+		{Op: Eq, IsImplied: true, Values: []string{"a", "b"}, Start: 0, End: 4},
+		{Op: "gt:", Values: []string{"1"}, Start: 4, End: 9},
+		{Op: "lt:", Values: []string{"5"}, Start: 9, End: 14},
+		{Op: "in:", Values: []string{"1", "2", "3"}, Start: 14, End: 23},
+	}
+	if fmt.Sprint(expr.Clauses) != fmt.Sprint(want) {
+		t.Fatalf("expected %v but got %v", want, expr.Clauses)
+	}
+	if got := fmt.Sprint(Parse("domain:example").Map()); got != "map[eq::[domain:example]]" {
+		t.Fatalf("expected operators to only be detected at delimiter boundaries but got %s", got)
+	}
+	if got := fmt.Sprint(ParseLegacy("domain:example").Map()); got != "map[eq::[doma] in::[example]]" {
+		t.Fatalf("expected legacy operators to be detected anywhere but got %s", got)
+	}
+	if got := fmt.Sprint(Parse("gt:1,like:a", "gt:").Map()); got != "map[gt::[1 like:a]]" {
+		t.Fatalf("expected only the provided operators to be detected but got %s", got)
+	}
+}
@@ -0,0 +1,20 @@
+package mongoqs
+
+// GridFSFields - Returns the QFields of a GridFS files collection (fs.files) for file-listing endpoints. _id, filename, length, and uploadDate are filterable, sortable, and projectable. Each of the provided metadata fields is stored under the document's metadata, so a `owner` field filters `metadata.owner`, and is otherwise used as provided.
+func GridFSFields(metadata ...QField) []QField {
+	id := NewQField("_id")
+	id.ParseAsObjectID().Sortable().Projectable()
+	filename := NewQField("filename")
+	filename.ParseAsString().Sortable().Projectable()
+	length := NewQField("length")
+	length.ParseAsInt().Sortable().Projectable()
+	uploadDate := NewQField("uploadDate")
+	uploadDate.ParseAsDateTime().Sortable().Projectable()
+
+	fields := []QField{id, filename, length, uploadDate}
+	for _, f := range metadata {
+		f.UseDBKey("metadata." + f.dbKey())
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -0,0 +1,99 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// groupsep - Separates the branches of an or or nor group
+const groupsep string = ";"
+
+// groupField - Returns the filterable field with the provided key or alias
+func (p *QProcessor) groupField(key string) (QField, bool) {
+	for _, f := range p.fields {
+		if !f.IsMeta && !f.IsNotFilterable && (f.Key == key || hasAlias(f, key)) {
+			return f, true
+		}
+	}
+	return QField{}, false
+}
+
+// hasOp - Returns true if one of the clauses uses the provided operator
+func hasOp(clauses []QClause, op string) bool {
+	for _, c := range clauses {
+		if c.Op == op {
+			return true
+		}
+	}
+	return false
+}
+
+// applyGroup - Adds a $or or $nor condition, depending on the reserved key, of the branches of the group to the out QResult. Each branch is <field>:<qvalue>, parsed and checked like the field's own query parameter. Branches that do not name a filterable field, do not produce a filter, or use near:, are ignored with a warning - under the field's key when the branch names a field.
+func (p *QProcessor) applyGroup(ctx context.Context, key string, qgroup string, query url.Values, fold func(string) string, now time.Time, roles *[]string, out *QResult) error {
+	conds := bson.A{}
+	for _, branch := range strings.Split(qgroup, groupsep) {
+		if branch == "" {
+			continue
+		}
+		source, qvalue := branch, ""
+		if i := strings.Index(branch, ":"); i >= 0 {
+			source, qvalue = branch[:i], branch[i+1:]
+		}
+		field, ok := p.groupField(source)
+		if !ok {
+			out.warn(key, branch, fmt.Sprintf("branch ignored - %q is not a filterable field", source))
+			continue
+		}
+		// warnings of branches naming a field are recorded under the field's key so values of PII fields are masked by Redacted
+		if qvalue == "" {
+			out.warn(field.Key, qvalue, fmt.Sprintf("%s branch ignored - missing value", key))
+			continue
+		}
+		qvalue = p.expandLists(qvalue)
+		if err := p.checkValue(ctx, field, source, qvalue, roles); err != nil {
+			return err
+		}
+		if field.IsFoldable {
+			field.fold = fold
+		}
+		field.features = p.features
+		field.now = now
+		if !isActive(field, query) {
+			// the interceptor only transforms values when the field is activated
+			field.Interceptor = nil
+		}
+		clauses, _, dropped, err := field.parse(qvalue, p.syntax)
+		if err != nil {
+			return err
+		}
+		if dropped != nil || len(clauses) == 0 {
+			out.warn(field.Key, qvalue, fmt.Sprintf("%s branch ignored - no valid filter", key))
+			continue
+		}
+		if hasOp(clauses, near) {
+			// MongoDB does not allow $near in $or or $nor
+			out.warn(field.Key, qvalue, fmt.Sprintf("%s branch ignored - %s cannot be used in a group", key, near))
+			continue
+		}
+		cond, err := MongoBackend{}.Condition(field.dbKey(), clauses)
+		if err != nil {
+			return err
+		}
+		conds = append(conds, cond)
+		// apply visibility conditions - they are mandatory so they are not part of the group
+		if field.Visibility != nil {
+			if vis := field.Visibility(ctx); len(vis) > 0 {
+				out.and(vis)
+			}
+		}
+	}
+	if len(conds) > 0 {
+		out.and(bson.M{"$" + key: conds})
+	}
+	return nil
+}
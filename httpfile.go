@@ -0,0 +1,63 @@
+package mongoqs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// examples - Map of QTypes to example values used in generated requests
+var examples map[QType][]string = map[QType][]string{
+	QString: {"example", "sample"},
+	QInt: {"1", "2"},
+	QFloat: {"1.5", "2.5"},
+	QDecimal: {"19.99", "100.00"},
+	QTimestamp: {"1700000000", "1700000100"},
+	QBool: {"true", "false"},
+	QDateTime: {"2021-01-01T00:00:00Z", "2021-02-01T00:00:00Z"},
+	QObjectID: {"5f9f1b9b9c9d440000000001", "5f9f1b9b9c9d440000000002"},
+	QIP: {"192.168.0.1", "10.0.0.0/8"},
+	QSemver: {"1.2.3", "2.0.0"},
+	QGeo: {"-73.97,40.77,5000"},
+	QCustom: {"value", "other"},
+}
+
+// opExamples - Map of operators to example values used instead of the type's examples because the operator takes a different form of value
+var opExamples map[string]string = map[string]string{within: "box(-74,40,-73,41)", exists: "true", emptyarray: "", re: "^example"}
+
+// HTTPFile - Returns an .http file, as used by the VS Code REST Client and JetBrains HTTP Client, with an example GET request to the provided URL for each field and operator combination, sort, and projection the processor accepts
+func (p *QProcessor) HTTPFile(target string) string {
+	var b strings.Builder
+	request := func(name string, query url.Values) {
+		fmt.Fprintf(&b, "### %s\nGET %s?%s\n\n", name, target, query.Encode())
+	}
+	for _, f := range p.fields {
+		if f.IsNotFilterable {
+			continue
+		}
+		values := examples[f.Type]
+		if f.IsMeta {
+			request(f.Key, url.Values{f.Key: {values[0]}})
+			continue
+		}
+		for _, op := range fieldOps(f, p.syntax) {
+			base, _ := negatedOp(op)
+			value := values[0]
+			if example, ok := opExamples[base]; ok {
+				value = example
+			} else if isListOp(op) || base == between {
+				value = strings.Join(values, ",")
+			}
+			request(f.Key+" "+strings.TrimSuffix(op, ":"), url.Values{f.Key: {op + value}})
+		}
+		if f.IsSortable {
+			request(f.Key+" ascending", url.Values{srt: {f.Key}})
+			request(f.Key+" descending", url.Values{srt: {exc + f.Key}})
+		}
+		if f.IsProjectable {
+			request(f.Key+" projection", url.Values{prj: {f.Key}})
+		}
+	}
+	request("limit and skip", url.Values{lmt: {"10"}, skp: {"10"}})
+	return b.String()
+}
@@ -0,0 +1,183 @@
+package mongoqs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QIPStorage - How a QIP field's addresses are stored in documents
+type QIPStorage int
+// IPString - Addresses are stored as strings in their canonical form, like 192.168.0.1. CIDR ranges are matched with prefix regular expressions. QIP fields use this storage by default.
+const IPString QIPStorage = 0
+// IPNumeric - IPv4 addresses are stored as integers, like 3232235521 for 192.168.0.1. CIDR ranges are matched with range comparisons.
+const IPNumeric QIPStorage = 1
+
+// parseIP - Parses a single address or a CIDR range. The network is nil for single addresses.
+func parseIP(v string) (net.IP, *net.IPNet, error) {
+	if strings.Contains(v, "/") {
+		ip, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ip, network, nil
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return nil, nil, fmt.Errorf("%q is not an IP address", v)
+	}
+	return ip, nil, nil
+}
+
+// ipNumber - Returns the numeric form of an IPv4 address
+func ipNumber(ip net.IP) (int64, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, errors.New("only IPv4 addresses can be stored as numbers")
+	}
+	return int64(ip4[0])<<24 | int64(ip4[1])<<16 | int64(ip4[2])<<8 | int64(ip4[3]), nil
+}
+
+// ipRange - Returns the first and last address of an IPv4 network in numeric form
+func ipRange(network *net.IPNet) (int64, int64, error) {
+	start, err := ipNumber(network.IP)
+	if err != nil {
+		return 0, 0, err
+	}
+	ones, bits := network.Mask.Size()
+	return start, start + (int64(1) << uint(bits-ones)) - 1, nil
+}
+
+// cidrRegex - Returns a regular expression matching the string form of every address in an IPv4 network
+func cidrRegex(network *net.IPNet) (primitive.Regex, error) {
+	ip4 := network.IP.To4()
+	if ip4 == nil {
+		return primitive.Regex{}, errors.New("only IPv4 ranges can be matched as strings")
+	}
+	ones, _ := network.Mask.Size()
+	octets := []string{}
+	for i := 0; i < ones/8; i++ {
+		octets = append(octets, strconv.Itoa(int(ip4[i])))
+	}
+	if rem := ones % 8; rem > 0 {
+		// the partially masked octet can be any of the values in its range
+		first := int(ip4[ones/8])
+		values := []string{}
+		for v := first; v < first+(1<<uint(8-rem)); v++ {
+			values = append(values, strconv.Itoa(v))
+		}
+		octets = append(octets, "(?:"+strings.Join(values, "|")+")")
+	}
+	pattern := "^" + strings.Join(octets, `\.`)
+	if len(octets) == 4 {
+		pattern += "$"
+	} else if len(octets) > 0 {
+		pattern += `\.`
+	}
+	return primitive.Regex{Pattern: pattern}, nil
+}
+
+// parseIPValue - Parses a single address in the field's storage form
+func (f *QField) parseIPValue(v string) (interface{}, error) {
+	ip, network, err := parseIP(v)
+	if err != nil {
+		return nil, err
+	}
+	if network != nil {
+		return nil, fmt.Errorf("%q is a range - only single addresses are allowed", v)
+	}
+	if f.IPStorage == IPNumeric {
+		return ipNumber(ip)
+	}
+	return ip.String(), nil
+}
+
+// ipClauses - Adds the clauses of a QIP field operator. Ranges used with eq:, ne:, in:, or nin: are matched with range comparisons for numeric storage, and with regular expressions in an $in or $nin for string storage.
+func (f *QField) ipClauses(op string, values []string, add func(op string, raw []string, value interface{}) error, invalid func(op string, v string)) error {
+	switch op {
+	case eq, ne, in, nin:
+		members := []interface{}{}
+		raws := []string{}
+		hasRange := false
+		for _, v := range values {
+			_, network, err := parseIP(v)
+			if err != nil {
+				invalid(op, v)
+				continue
+			}
+			if network == nil {
+				if value, err := f.parseIPValue(v); err == nil {
+					members = append(members, value)
+					raws = append(raws, v)
+				} else {
+					invalid(op, v)
+				}
+				continue
+			}
+			if f.IPStorage == IPNumeric {
+				start, end, err := ipRange(network)
+				if err != nil || op != eq {
+					// only eq: can be expressed with range comparisons on a single field
+					invalid(op, v)
+					continue
+				}
+				if err := add(gte, []string{v}, start); err != nil {
+					return err
+				}
+				if err := add(lte, []string{v}, end); err != nil {
+					return err
+				}
+				continue
+			}
+			re, err := cidrRegex(network)
+			if err != nil {
+				invalid(op, v)
+				continue
+			}
+			members = append(members, re)
+			raws = append(raws, v)
+			hasRange = true
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		if (op == eq || op == ne) && !hasRange {
+			// multiple eq: values are applied in order so the last one is used, like other types
+			for i, m := range members {
+				if err := add(op, raws[i:i+1], m); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		// ranges are matched by regular expressions, which are only evaluated in lists
+		if op == eq {
+			op = in
+		} else if op == ne {
+			op = nin
+		}
+		return add(op, raws, members)
+	case gt, gte, lt, lte:
+		if f.IPStorage != IPNumeric {
+			// string forms of addresses do not sort numerically
+			invalid(op, strings.Join(values, ","))
+			return nil
+		}
+		for _, v := range values {
+			if value, err := f.parseIPValue(v); err == nil {
+				if err := add(op, []string{v}, value); err != nil {
+					return err
+				}
+			} else {
+				invalid(op, v)
+			}
+		}
+	default:
+		invalid(op, strings.Join(values, ","))
+	}
+	return nil
+}
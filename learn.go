@@ -0,0 +1,74 @@
+package mongoqs
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// maxLearnedKeys - Maximum number of undeclared keys a processor remembers, so clients sending random keys cannot grow memory without bound
+const maxLearnedKeys = 1000
+
+// QLearnedKey - An undeclared query key seen by a learning processor
+type QLearnedKey struct {
+	Key string // Query parameter key
+	Queries int64 // Number of processed queries that used the key
+}
+
+// learner - Collects undeclared query keys across concurrent requests
+type learner struct {
+	mu sync.Mutex
+	keys map[string]int64 // Map of undeclared keys to the number of queries that used them
+}
+
+// record - Adds the keys of the query that are not field keys, aliases, or reserved keys to the learned keys
+func (l *learner) record(p *QProcessor, query url.Values) {
+	declared := make(map[string]bool, len(p.fields))
+	for _, f := range p.fields {
+		for _, k := range append([]string{f.Key}, f.Aliases...) {
+			declared[k] = true
+			if p.features.EnableBrackets {
+				declared[k+"[]"] = true
+			}
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range query {
+		if declared[key] || isReserved(key) {
+			continue
+		}
+		if _, ok := l.keys[key]; !ok && len(l.keys) >= maxLearnedKeys {
+			continue
+		}
+		l.keys[key]++
+	}
+}
+
+// Learn - Enables learning mode, where query keys that are not field keys, aliases, or reserved keys are recorded, without being applied, so maintainers can discover which filters clients want before declaring them. Keys are safe to record from concurrent requests and can be read with Learned. Values are never recorded, and at most 1000 distinct keys are remembered. Returns caller for chaining.
+func (p *QProcessor) Learn() *QProcessor {
+	if p.learner == nil {
+		p.learner = &learner{keys: make(map[string]int64)}
+	}
+	return p
+}
+
+// Learned - Returns the undeclared keys recorded since Learn was called, ordered by the number of queries that used them and then by key. Returns an empty list if the processor is not learning.
+func (p *QProcessor) Learned() []QLearnedKey {
+	learned := []QLearnedKey{}
+	if p.learner == nil {
+		return learned
+	}
+	p.learner.mu.Lock()
+	for k, n := range p.learner.keys {
+		learned = append(learned, QLearnedKey{Key: k, Queries: n})
+	}
+	p.learner.mu.Unlock()
+	sort.Slice(learned, func(i, j int) bool {
+		if learned[i].Queries != learned[j].Queries {
+			return learned[i].Queries > learned[j].Queries
+		}
+		return learned[i].Key < learned[j].Key
+	})
+	return learned
+}
@@ -0,0 +1,64 @@
+package mongoqs
+
+import (
+	"net/url"
+	"strings"
+)
+
+// QCardinality - How many distinct values a field has, used by Lint
+type QCardinality int
+// CardinalityUnknown - The number of distinct values is not known. QFields use this cardinality by default.
+const CardinalityUnknown QCardinality = 0
+// CardinalityLow - The field has few distinct values, like a status or a boolean flag
+const CardinalityLow QCardinality = 1
+// CardinalityHigh - The field has mostly distinct values in a large collection, like a timestamp or a counter
+const CardinalityHigh QCardinality = 2
+
+// QLintFinding - An advisory finding about a filter that is likely to be slow
+type QLintFinding struct {
+	Field string // Field key
+	Operator string // Operator that was used
+	Message string // Why the filter is likely to be slow
+}
+
+// Lint - Processes the query without executing it and returns advisory findings for filters that are likely to be slow: like:, slike:, elike:, and unanchored re: filters, which cannot use an index efficiently because they are unanchored or case-insensitive, ranges with only a lower or upper bound on CardinalityHigh fields, and ne: and nin: filters on CardinalityLow fields. Useful in CI checks of documented example queries. Returns an error if the query cannot be processed.
+func (p *QProcessor) Lint(query url.Values) ([]QLintFinding, error) {
+	// lint queries are not recorded in the usage statistics or learned keys
+	linter := *p
+	linter.usage = nil
+	linter.learner = nil
+	result, err := linter.Process(query)
+	if err != nil {
+		return nil, err
+	}
+	findings := []QLintFinding{}
+	for _, field := range p.fields {
+		clauses := result.Parsed[field.dbKey()]
+		lower, upper := "", ""
+		for _, c := range clauses {
+			switch c.Op {
+			case like, elike, not + like, not + elike:
+				findings = append(findings, QLintFinding{Field: field.Key, Operator: c.Op, Message: "unanchored regular expressions scan every index key or document"})
+			case slike, not + slike:
+				// slike: is anchored but case-insensitive, so the prefix cannot bound the index scan
+				findings = append(findings, QLintFinding{Field: field.Key, Operator: c.Op, Message: "case-insensitive regular expressions scan every index key"})
+			case re:
+				if pattern, _ := c.Value.(string); !strings.HasPrefix(pattern, "^") {
+					findings = append(findings, QLintFinding{Field: field.Key, Operator: c.Op, Message: "unanchored regular expressions scan every index key or document"})
+				}
+			case gt, gte:
+				lower = c.Op
+			case lt, lte:
+				upper = c.Op
+			case ne, nin:
+				if field.Cardinality == CardinalityLow {
+					findings = append(findings, QLintFinding{Field: field.Key, Operator: c.Op, Message: "negations of a low cardinality field match most documents and cannot use an index selectively"})
+				}
+			}
+		}
+		if field.Cardinality == CardinalityHigh && (lower == "") != (upper == "") {
+			findings = append(findings, QLintFinding{Field: field.Key, Operator: lower + upper, Message: "ranges with only one bound on a high cardinality field can match most of the collection"})
+		}
+	}
+	return findings, nil
+}
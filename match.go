@@ -0,0 +1,409 @@
+package mongoqs
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QPredicate - Reports whether a document matches a filter
+type QPredicate func(doc bson.M) bool
+
+// Match - Compiles the QResult Filter into a predicate that evaluates it against documents in memory, so filter semantics can be unit tested against fixture documents without a running MongoDB. See MatchFilter for the supported operators.
+func (r *QResult) Match() (QPredicate, error) {
+	return MatchFilter(r.Filter)
+}
+
+// MatchFilter - Compiles a MongoDB filter into a predicate that evaluates it against documents in memory. Supports $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $all, $regex with $options, $bitsAllSet, $bitsAnySet, $bitsAllClear, $and, $or, and $nor with MongoDB array semantics, where a condition on an array matches if any element matches. An error is returned for any other operator.
+func MatchFilter(filter bson.M) (QPredicate, error) {
+	preds := []QPredicate{}
+	for key, cond := range filter {
+		var pred QPredicate
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			pred, err = matchLogical(key, cond)
+		default:
+			pred, err = matchField(key, cond)
+		}
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+	}
+	return func(doc bson.M) bool {
+		for _, pred := range preds {
+			if !pred(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// matchLogical - Compiles a logical operator and its list of filters
+func matchLogical(op string, cond interface{}) (QPredicate, error) {
+	preds := []QPredicate{}
+	for _, f := range toList(cond) {
+		filter, ok := f.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a list of filters - got %T", op, f)
+		}
+		pred, err := MatchFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+	}
+	return func(doc bson.M) bool {
+		for _, pred := range preds {
+			matched := pred(doc)
+			if op == "$and" && !matched {
+				return false
+			}
+			if op == "$or" && matched {
+				return true
+			}
+			if op == "$nor" && matched {
+				return false
+			}
+		}
+		return op != "$or"
+	}, nil
+}
+
+// matchField - Compiles the condition of a field, which is either a map of operators or a value that must be equal
+func matchField(key string, cond interface{}) (QPredicate, error) {
+	path := strings.Split(key, ".")
+	ops, ok := cond.(bson.M)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(firstKey(ops), "$") {
+		return func(doc bson.M) bool {
+			return anyValue(lookup(doc, path), func(v interface{}) bool { return equal(v, cond) })
+		}, nil
+	}
+	match, err := matchOps(key, ops)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc bson.M) bool {
+		return match(lookup(doc, path))
+	}, nil
+}
+
+// matchOps - Compiles a map of operators of a field to a predicate of the field's values
+func matchOps(key string, ops bson.M) (func(values []interface{}) bool, error) {
+	preds := []func(values []interface{}) bool{}
+	for op, operand := range ops {
+		operand := operand
+		switch op {
+		case "$eq":
+			preds = append(preds, func(values []interface{}) bool {
+				// null also matches missing fields
+				return (isNull(operand) && len(values) == 0) || anyValue(values, func(v interface{}) bool { return equal(v, operand) })
+			})
+		case "$ne":
+			preds = append(preds, func(values []interface{}) bool {
+				return !(isNull(operand) && len(values) == 0) && !anyValue(values, func(v interface{}) bool { return equal(v, operand) })
+			})
+		case "$exists":
+			want, ok := operand.(bool)
+			if !ok {
+				return nil, fmt.Errorf("field %q $exists expects a bool - got %T", key, operand)
+			}
+			preds = append(preds, func(values []interface{}) bool {
+				return (len(values) > 0) == want
+			})
+		case "$gt", "$gte", "$lt", "$lte":
+			op := op
+			preds = append(preds, func(values []interface{}) bool {
+				return anyValue(values, func(v interface{}) bool {
+					c, ok := compare(v, operand)
+					if !ok {
+						return false
+					}
+					switch op {
+					case "$gt":
+						return c > 0
+					case "$gte":
+						return c >= 0
+					case "$lt":
+						return c < 0
+					}
+					return c <= 0
+				})
+			})
+		case "$in", "$nin":
+			list := toList(operand)
+			in := func(values []interface{}) bool {
+				return anyValue(values, func(v interface{}) bool {
+					for _, member := range list {
+						if equal(v, member) {
+							return true
+						}
+					}
+					return false
+				})
+			}
+			if op == "$in" {
+				preds = append(preds, in)
+			} else {
+				preds = append(preds, func(values []interface{}) bool { return !in(values) })
+			}
+		case "$all":
+			list := toList(operand)
+			preds = append(preds, func(values []interface{}) bool {
+				for _, member := range list {
+					if !anyValue(values, func(v interface{}) bool { return equal(v, member) }) {
+						return false
+					}
+				}
+				return len(list) > 0
+			})
+		case "$bitsAllSet", "$bitsAnySet", "$bitsAllClear":
+			mask, ok := toMask(operand)
+			if !ok {
+				return nil, fmt.Errorf("field %q %s expects a bitmask or bit positions - got %T", key, op, operand)
+			}
+			op := op
+			preds = append(preds, func(values []interface{}) bool {
+				return anyValue(values, func(v interface{}) bool {
+					n, ok := normalize(v).(float64)
+					if !ok || n != float64(int64(n)) {
+						return false
+					}
+					switch op {
+					case "$bitsAllSet":
+						return int64(n)&mask == mask
+					case "$bitsAnySet":
+						return int64(n)&mask != 0
+					}
+					return int64(n)&mask == 0
+				})
+			})
+		case "$regex":
+			pattern, ok := operand.(string)
+			if !ok {
+				return nil, fmt.Errorf("field %q $regex expects a string - got %T", key, operand)
+			}
+			if options, _ := ops["$options"].(string); strings.Contains(options, "i") {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("field %q $regex is not valid: %w", key, err)
+			}
+			preds = append(preds, func(values []interface{}) bool {
+				return anyValue(values, func(v interface{}) bool {
+					s, ok := v.(string)
+					return ok && re.MatchString(s)
+				})
+			})
+		case "$not":
+			inner, ok := operand.(bson.M)
+			if !ok {
+				return nil, fmt.Errorf("field %q $not expects operators - got %T", key, operand)
+			}
+			match, err := matchOps(key, inner)
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, func(values []interface{}) bool { return !match(values) })
+		case "$options":
+			// applied with $regex
+		default:
+			return nil, fmt.Errorf("field %q operator %q is not supported by in-memory matching", key, op)
+		}
+	}
+	return func(values []interface{}) bool {
+		for _, pred := range preds {
+			if !pred(values) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// toMask - Converts a bitmask or a list of bit positions to a bitmask
+func toMask(v interface{}) (int64, bool) {
+	if n, ok := normalize(v).(float64); ok {
+		return int64(n), true
+	}
+	list := toList(v)
+	if list == nil {
+		return 0, false
+	}
+	var mask int64
+	for _, p := range list {
+		n, ok := normalize(p).(float64)
+		if !ok {
+			return 0, false
+		}
+		mask |= 1 << uint(n)
+	}
+	return mask, true
+}
+
+// firstKey - Returns any key of the map
+func firstKey(m bson.M) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+// lookup - Returns the values at the dot notation path of the document. Arrays along the path are traversed, and arrays at the end of the path are returned along with each of their elements so conditions can match the array or any element.
+func lookup(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if list, ok := v.(bson.A); ok {
+			return append([]interface{}{v}, list...)
+		}
+		if list, ok := v.([]interface{}); ok {
+			return append([]interface{}{v}, list...)
+		}
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.M:
+		if child, ok := t[path[0]]; ok {
+			return lookup(child, path[1:])
+		}
+	case map[string]interface{}:
+		if child, ok := t[path[0]]; ok {
+			return lookup(child, path[1:])
+		}
+	case bson.A, []interface{}:
+		values := []interface{}{}
+		for _, child := range toList(t) {
+			values = append(values, lookup(child, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// anyValue - Returns true if the predicate is true for any of the values
+func anyValue(values []interface{}, pred func(v interface{}) bool) bool {
+	for _, v := range values {
+		if pred(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// toList - Returns the elements of any slice or array, or nil if the value is not a list
+func toList(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list
+}
+
+// normalize - Converts values to comparable forms: numbers to float64, dates to milliseconds since the epoch, and object IDs to hex strings
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int32:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case float32:
+		return float64(t)
+	case primitive.Decimal128:
+		// compared as the nearest float64, so decimals that differ beyond float precision compare as equal
+		if f, err := strconv.ParseFloat(t.String(), 64); err == nil {
+			return f
+		}
+	case primitive.DateTime:
+		return int64(t)
+	case time.Time:
+		return int64(primitive.NewDateTimeFromTime(t))
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.Timestamp:
+		// timestamps are ordered by seconds, then increment
+		return int64(t.T)<<32 | int64(t.I)
+	}
+	return v
+}
+
+// compare - Returns -1, 0, or 1 when a is less than, equal to, or greater than b, and false if they cannot be compared
+func compare(a, b interface{}) (int, bool) {
+	switch x := normalize(a).(type) {
+	case float64:
+		if y, ok := normalize(b).(float64); ok {
+			return cmp(x < y, x > y), true
+		}
+	case int64:
+		if y, ok := normalize(b).(int64); ok {
+			return cmp(x < y, x > y), true
+		}
+	case string:
+		if y, ok := normalize(b).(string); ok {
+			return cmp(x < y, x > y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return cmp(!x && y, x && !y), true
+		}
+	}
+	return 0, false
+}
+
+// cmp - Converts less and greater results to -1, 0, or 1
+func cmp(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// isNull - Returns true if the value is nil or a BSON null
+func isNull(v interface{}) bool {
+	_, ok := v.(primitive.Null)
+	return v == nil || ok
+}
+
+// equal - Returns true if the values are equal after normalizing them, or if a is a string matching the regular expression b
+func equal(a, b interface{}) bool {
+	if isNull(a) || isNull(b) {
+		return isNull(a) && isNull(b)
+	}
+	if re, ok := b.(primitive.Regex); ok {
+		// regular expressions in $in and $nin lists match strings
+		s, ok := a.(string)
+		return ok && regexp.MustCompile(re.Pattern).MatchString(s)
+	}
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	if la, lb := toList(a), toList(b); la != nil && lb != nil {
+		// arrays are equal when their elements are equal in order, whatever their slice types
+		if len(la) != len(lb) {
+			return false
+		}
+		for i := range la {
+			if !equal(la[i], lb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
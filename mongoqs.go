@@ -1,15 +1,19 @@
 package mongoqs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/url"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/rledford/mongoqs/grammar"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -28,60 +32,353 @@ const all string = "all:" // has all in list of values
 // sort operators
 const asc string = "+" // ascending
 const des string = "-" // decending
+const sasc string = ":asc" // ascending suffix
+const sdes string = ":desc" // decending suffix
 
 // projection operators
 const inc string = "+" // include
 const exc string = "-" // exclude
 
+// form encoding decodes + as a space so a leading space in a sort or projection is treated as +
+const spc string = " "
+
 // search operators (string fields only)
 const like string = "like:" // includes sequence
 const slike string = "slike:" // starts with sequence
 const elike string = "elike:" // ends with sequence
+const re string = "re:" // matches a regular expression - only allowed on fields that allow regular expressions
+
+// bitwise operators (int fields only)
+const bitsallset string = "bitsallset:" // all bits set
+const bitsanyset string = "bitsanyset:" // any bits set
+const bitsallclear string = "bitsallclear:" // all bits clear
+
+// array operators (any field type)
+const emptyarray string = "emptyarray:" // equal to an empty array - takes no values
+
+// range operators (int, float, decimal, datetime, objectid, and custom fields only)
+const between string = "between:" // greater than or equal to the first value and less than or equal to the second value - expands to gte: and lte:
+
+// geospatial operators (geo fields only)
+const near string = "near:" // nearest to a point - <lng>,<lat>,<max meters>,<min meters> with optional distances - results are sorted by distance
+const within string = "within:" // inside a shape - poly(...), box(...), or circle(...)
+
+// element operators (any field type)
+const exists string = "exists:" // field is present - takes true or false
+
+// negation prefixes (any operator in negatable)
+const not string = "not:" // negates the operator that follows it - the canonical prefix
+const bang string = "!" // shorthand for not:
+
+// negatable - Operators that can be negated with not: or !. Negated operators match documents where the operator does not match, including documents without the field.
+var negatable []string = []string{eq, gt, gte, lt, lte, in, all, like, slike, elike, bitsallset, bitsanyset, bitsallclear}
 
 // reserved query fields
 const lmt string = "lmt" // MongoDB query limit count
 const skp string = "skp" // MongoDB query skip count
 const srt string = "srt" // MongoDB query sort
 const prj string = "prj" // MongoDB query projection
+const unl string = "unl" // MongoDB query without a client limit - only allowed when the processor allows unlimited queries
+const ndf string = "ndf" // list of fields, or all, that should not use their Default function - only allowed when the processor allows default suppression
+const ndfall string = "all" // ndf value that suppresses all defaults
+const tpl string = "tpl" // filter template invocation - <name>:<arg>,<arg>
+const vec string = "vec" // vector search reference - only allowed when the processor has vector search enabled
+const sch string = "sch" // Atlas Search text - only allowed when the processor has Atlas Search enabled
+const hlt string = "hlt" // Atlas Search highlights - only used with sch
+const msc string = "msc" // minimum relevance score - only used with sch or vec
+const fld string = "fld" // case folding of the values of foldable fields - lower or upper
+const or string = "or" // group of field filters where at least one must match - <field>:<qvalue>;<field>:<qvalue>
+const nor string = "nor" // group of field filters where none may match - <field>:<qvalue>;<field>:<qvalue>
+
+// reserved query field list
+var reserved []string = []string{lmt, skp, srt, prj, unl, ndf, tpl, vec, sch, hlt, msc, fld, or, nor}
+
+// isReserved - Returns true if the provided key is a reserved query field
+func isReserved(key string) bool {
+	for _, r := range reserved {
+		if key == r {
+			return true
+		}
+	}
+	return false
+}
 
 // qvalue op list
-var oplist []string = []string{eq, ne, gt, gte, lt, lte, in, nin, all, like, slike, elike}
-var opregex *regexp.Regexp = regexp.MustCompile(strings.Join(oplist, "|"))
+var oplist []string = []string{eq, ne, gt, gte, lt, lte, in, nin, all, like, slike, elike, bitsallset, bitsanyset, bitsallclear, emptyarray, exists, between, re, near, within}
+
+// list references
+const listref string = "@" // prefix of a reference to a server-side list
+var listname *regexp.Regexp = regexp.MustCompile(`^\w+$`)
+var listregex *regexp.Regexp = regexp.MustCompile(`(^|,|:)@\w+`)
+
+// QSyntax - Version of the MongoQS query string syntax. Grammar changes are introduced in new syntax versions so processors pinned to an older version keep parsing query strings exactly as they did before.
+type QSyntax int
+// SyntaxV1 - The original syntax.
+const SyntaxV1 QSyntax = 1
+// SyntaxV2 - Adds :asc and :desc sort suffixes and treats a leading space in sorts and projections as +.
+const SyntaxV2 QSyntax = 2
+// SyntaxV3 - Adds the bitsallset:, bitsanyset:, and bitsallclear: operators.
+const SyntaxV3 QSyntax = 3
+// SyntaxV4 - Adds the emptyarray: operator.
+const SyntaxV4 QSyntax = 4
+// SyntaxV5 - Adds the exists: operator.
+const SyntaxV5 QSyntax = 5
+// SyntaxV6 - Adds the between: operator.
+const SyntaxV6 QSyntax = 6
+// SyntaxV7 - Adds the not: and ! operator negation prefixes.
+const SyntaxV7 QSyntax = 7
+// SyntaxV8 - Adds the re: operator.
+const SyntaxV8 QSyntax = 8
+// SyntaxV9 - Adds the near: and within: operators.
+const SyntaxV9 QSyntax = 9
+// SyntaxV10 - Operators are only detected at the start of a value or after a , so values that contain an operator are not split.
+const SyntaxV10 QSyntax = 10
+// SyntaxLatest - The syntax used by processors that are not pinned to a version.
+const SyntaxLatest QSyntax = SyntaxV10
+
+// opsince - Map of operators to the syntax version that introduced them
+var opsince map[string]QSyntax = map[string]QSyntax{eq: SyntaxV1, ne: SyntaxV1, gt: SyntaxV1, gte: SyntaxV1, lt: SyntaxV1, lte: SyntaxV1, in: SyntaxV1, nin: SyntaxV1, all: SyntaxV1, like: SyntaxV1, slike: SyntaxV1, elike: SyntaxV1, bitsallset: SyntaxV3, bitsanyset: SyntaxV3, bitsallclear: SyntaxV3, emptyarray: SyntaxV4, exists: SyntaxV5, between: SyntaxV6, re: SyntaxV8, near: SyntaxV9, within: SyntaxV9}
+
+// mops - Map of operators to MongoDB operators that are not the operator with a leading $
+var mops map[string]string = map[string]string{bitsallset: "$bitsAllSet", bitsanyset: "$bitsAnySet", bitsallclear: "$bitsAllClear", emptyarray: "$eq", within: "$geoWithin"}
 
 // toMOp - Adds leading $ to the provided operator
 func toMOp(op string) string {
+	if mop, ok := mops[op]; ok {
+		return mop
+	}
 	return "$" + op[0:len(op) - 1]
 }
 
-// toOpValueMap - Builds a map of operator keys to values
-func toOpValueMap(qvalue string, t QType) map[string][]string {
-	result := make(map[string][]string)
-	opindexes := opregex.FindAllStringIndex(qvalue, len(qvalue))
-	if len(opindexes) > 0 {
-		if opindexes[0][0] > 0 {
-			// operator not found at beginning of qvalue, assuming eq: up to first found operator
-			result[eq] = append(result[eq], strings.Split(strings.TrimSuffix(qvalue[0:opindexes[0][0]], ","),",")...)
-		}
-		for i, oi := range opindexes {
-			op := qvalue[oi[0]:oi[1]]
-			if i + 1 < len(opindexes) {
-				// get a slice of qvalue from the end of the operator to the beginning of the next operator - values split at ,
-				endindex := opindexes[i+1][0]
-				value := strings.Split(strings.TrimSuffix(qvalue[oi[1]:endindex], ","),",")
-				result[op] = append(result[op], value...)
-			} else {
-				// get a slice from the end of the current operator to the end of the qvalue - values split at ,
-				result[op] = append(result[op], strings.Split(strings.TrimSuffix(qvalue[oi[1]:], ","),",")...)
+// syntaxops - Map of syntax versions to the operators they recognize
+var syntaxops map[QSyntax][]string = toSyntaxOps()
+
+// toSyntaxOps - Builds a map of each syntax version to the operators it recognizes
+func toSyntaxOps() map[QSyntax][]string {
+	result := make(map[QSyntax][]string)
+	for syntax := SyntaxV1; syntax <= SyntaxLatest; syntax++ {
+		for _, op := range oplist {
+			if opsince[op] <= syntax {
+				result[syntax] = append(result[syntax], op)
 			}
 		}
-	} else {
-		// no operators found, assuming eq: for entire qvalue
-		result[eq] = append(result[eq], strings.Split(strings.TrimSuffix(qvalue, ","),",")...)
+		if syntax >= SyntaxV7 {
+			result[syntax] = append(result[syntax], grammar.Negate(negatable...)...)
+		}
+	}
+	return result
+}
+
+// fieldOps - Returns the operators, including negated operators, that the field can parse into a filter with the provided syntax version, in the order they are recognized. The JSON Schema, TypeScript, and .http exporters use it so they describe the operators the processor accepts.
+func fieldOps(f QField, syntax QSyntax) []string {
+	ops := []string{}
+	for _, op := range syntaxops[syntax] {
+		base, negated := negatedOp(op)
+		if negated && (f.Type == QIP || f.Type == QSemver) {
+			// addresses and versions can become more than one clause, which cannot be negated one at a time
+			continue
+		}
+		if f.acceptsOp(base) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// acceptsOp - Returns true if the field can parse values of the provided operator
+func (f *QField) acceptsOp(op string) bool {
+	coerced := len(f.Coercion) > 0
+	switch op {
+	case emptyarray, exists:
+		return true
+	case like, slike, elike:
+		return f.Type == QString
+	case re:
+		return f.Type == QString && f.IsRegexAllowed && !coerced
+	case bitsallset, bitsanyset, bitsallclear:
+		return f.Type == QInt && !coerced
+	case near, within:
+		return f.Type == QGeo && !coerced
+	case between:
+		return !coerced && (f.Type == QInt || f.Type == QFloat || f.Type == QDecimal || f.Type == QDateTime || f.Type == QObjectID || f.Type == QCustom)
 	}
+	switch {
+	case coerced:
+		return true
+	case f.Type == QGeo:
+		// locations are only compared with shapes and distances
+		return false
+	case f.Type == QIP:
+		// string forms of addresses do not sort numerically
+		return op == eq || op == ne || op == in || op == nin || (f.IPStorage == IPNumeric && (op == gt || op == gte || op == lt || op == lte))
+	}
+	return true
+}
+
+// isListOp - Returns true if the operator, or the operator it negates, takes a list of values
+func isListOp(op string) bool {
+	op, _ = negatedOp(op)
+	return op == in || op == nin || op == all
+}
+
+// parseQValue - Parses the qvalue with the operators of the provided syntax version. Syntax versions before SyntaxV10 detect operators anywhere in the qvalue.
+func parseQValue(qvalue string, syntax QSyntax) grammar.Expr {
+	if syntax < SyntaxV10 {
+		return grammar.ParseLegacy(qvalue, syntaxops[syntax]...)
+	}
+	return grammar.Parse(qvalue, syntaxops[syntax]...)
+}
+
+// negatedOp - Returns the operator negated by the provided operator and true if it starts with not: or !
+func negatedOp(op string) (string, bool) {
+	switch {
+	case strings.HasPrefix(op, not):
+		return op[len(not):], true
+	case strings.HasPrefix(op, bang):
+		return op[len(bang):], true
+	}
+	return op, false
+}
+
+// canonicalOp - Returns the operator with a ! negation replaced by not:
+func canonicalOp(op string) string {
+	if base, ok := negatedOp(op); ok {
+		return not + base
+	}
+	return op
+}
 
+// toOpValueMap - Builds a map of operator keys to values. Operators introduced after the provided syntax version are treated as values.
+func toOpValueMap(qvalue string, t QType, syntax QSyntax) map[string][]string {
+	result := make(map[string][]string)
+	for op, values := range parseQValue(qvalue, syntax).Map() {
+		op = canonicalOp(op)
+		result[op] = append(result[op], values...)
+	}
 	return result
 }
 
+// isOp - Returns true if the provided operator, with or without a trailing :, is in the qvalue op list
+func isOp(op string) bool {
+	if !strings.HasSuffix(op, ":") {
+		op += ":"
+	}
+	for _, o := range oplist {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// toSort - Converts a srt entry to a sort key and order using the provided syntax version
+func toSort(entry string, syntax QSyntax) (string, int) {
+	if syntax >= SyntaxV2 {
+		if strings.HasSuffix(entry, sasc) {
+			return strings.TrimSuffix(entry, sasc), 1
+		} else if strings.HasSuffix(entry, sdes) {
+			return strings.TrimSuffix(entry, sdes), -1
+		} else if strings.HasPrefix(entry, spc) {
+			return strings.TrimLeft(entry, spc), 1
+		}
+	}
+	if strings.HasPrefix(entry, asc) {
+		return entry[1:], 1
+	} else if strings.HasPrefix(entry, des) {
+		return entry[1:], -1
+	}
+	return entry, 1
+}
+
+// hasAlias - Returns true if the field has the provided alias
+func hasAlias(field QField, alias string) bool {
+	for _, a := range field.Aliases {
+		if a == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// isSuppressed - Returns true if the field's key or one of its aliases is in the map of suppressed defaults, or if all defaults are suppressed
+func isSuppressed(field QField, nodefaults map[string]bool) bool {
+	if nodefaults[ndfall] || nodefaults[field.Key] {
+		return true
+	}
+	for _, a := range field.Aliases {
+		if nodefaults[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// isActive - Returns true if the field's Default function and Interceptor are active for the query
+func isActive(field QField, query url.Values) bool {
+	if len(field.ActiveWhen) == 0 {
+		return true
+	}
+	for _, key := range field.ActiveWhen {
+		if query.Get(key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRole - Returns true if any of the roles are in the allowed list
+func hasRole(roles []string, allowed []string) bool {
+	for _, r := range roles {
+		for _, a := range allowed {
+			if r == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrOperatorNotAllowed - Returned, wrapped with details, when a query uses an operator the caller's roles do not allow.
+var ErrOperatorNotAllowed = errors.New("operator not allowed")
+
+// ErrInternal - Wrapped by QPanicError when processing a query panics.
+var ErrInternal = errors.New("internal error")
+
+// QPanicError - Returned by the processor, instead of panicking, when processing a query panics. Value is the recovered value and Stack is the stack trace of the panic.
+type QPanicError struct {
+	Value interface{} // Value passed to panic
+	Stack []byte // Stack trace of the goroutine that panicked
+}
+// Error - Returns the error message
+func (e *QPanicError) Error() string {
+	return fmt.Sprintf("%v: panic while processing query: %v", ErrInternal, e.Value)
+}
+// Unwrap - Returns ErrInternal so the error can be checked with errors.Is
+func (e *QPanicError) Unwrap() error {
+	return ErrInternal
+}
+
+// ErrValueNotValid - Returned, wrapped with details, when a query value fails its field's validation tag.
+var ErrValueNotValid = errors.New("value not valid")
+
+// QErrors - Returned by best-effort processors, alongside a usable QResult, when parts of a query failed. Each error is one skipped field, template, or search parameter in the order it was found.
+type QErrors []error
+// Error - Returns the messages of every error separated by semicolons
+func (e QErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+// Unwrap - Returns the errors so each can be checked with errors.Is and errors.As. Only Go 1.20 and later look through an Unwrap that returns multiple errors - on earlier versions range over the QErrors instead.
+func (e QErrors) Unwrap() []error {
+	return e
+}
+
+// ErrValueConflict - Returned, wrapped with details, when a field using MergeReject has values for more than one of its key and aliases.
+var ErrValueConflict = errors.New("conflicting values")
+
 // QueryProcessorFn - function signature for a query processor
 type QueryProcessorFn func(q url.Values) (QResult, error)
 
@@ -91,28 +388,106 @@ type QResult struct {
 	Projection bson.M // MongoDB projection
 	Limit int64 // MongoDB document limit
 	Skip int64 // MongoDB ocument skip count
-	Sort bson.M // MongoDB sort
+	Sort bson.D // MongoDB sort - ordered by precedence
 	Meta map[string]string // Map of keys to raw qstring value
+	AddFields bson.M // MongoDB $addFields stage for computed projections - only applied by Pipeline
+	SortInputs map[string]string // Map of Sort keys to the srt entry that produced them - useful when a sort was requested with an alias or preset
+	SortFields map[string]string // Map of Sort keys to the key of the QField they belong to - only differs when the QField has a DBKey
+	Warnings []QWarning // Query parameters that were ignored or changed during processing
+	DefaultsApplied []string // Keys of fields whose Filter or Meta value came from their Default function
+	Search bson.M // Atlas $search stage - only set when the query used sch - applied by Pipeline before $match
+	VectorSearch bson.M // Atlas $vectorSearch stage without its filter - only set when the query used vec - applied by Pipeline in place of $match with the Filter as its pre-filter
+	MinScore float64 // Minimum search or vector search score of returned documents - applied by Pipeline after the search stage when greater than 0
+	TargetsArchive bool // If true, the Filter can match documents older than the processor's archive routing cutoff
+	ValueCounts map[string]QValueCounts // Map of the keys of filtered fields to the number of their values that could and could not be parsed
+	IsRedacted bool // If true, values of PII fields are masked when the QResult is rendered or logged
+	Parsed map[string][]QClause // Map of the document paths of filtered fields to their parsed clauses, in the order the operators appeared - the path is the field Key unless the field has a DBKey
+	clauseKeys []string // Keys of clauses in the order they were parsed
+	pii map[string]bool // Keys, aliases, and document paths of PII fields - used by Redacted
+	slots map[string]bson.M // Map of slot names to the fragments attached to the Filter
+}
+
+// QValueCounts - The number of values of a field that could and could not be parsed, so clients can be told when some of the values they sent were ignored
+type QValueCounts struct {
+	Valid int // Number of values that were parsed
+	Invalid int // Number of values that could not be parsed, or were not valid for their operator
+}
+
+// QWarning - Describes a query parameter that was ignored or changed during processing so clients can be told why a query did not behave as expected.
+type QWarning struct {
+	Key string // The query parameter the warning applies to
+	Value string // The raw query parameter value
+	Message string // Description of what happened to the value
+}
+func (w QWarning) String() string {
+	return fmt.Sprintf("%s=%s: %s", w.Key, w.Value, w.Message)
+}
+
+// and - Adds the condition to the Filter's $and list so it is combined with the other conditions instead of replacing them
+func (r *QResult) and(cond bson.M) {
+	conds, _ := r.Filter["$and"].(bson.A)
+	r.Filter["$and"] = append(conds, cond)
+}
+
+// warn - Adds a warning to the QResult
+func (r *QResult) warn(key, value, message string) {
+	r.Warnings = append(r.Warnings, QWarning{Key: key, Value: value, Message: message})
 }
+// String - Returns the QResult rendered with FormatText
 func (r *QResult) String() string {
-	return fmt.Sprintf(`
-	----- Filter -----
-	%v
-	------------------
-	--- Projection ---
-	%v
-	------------------
-	------ Sort ------
-	%v
-	------------------
-	----- Paging -----
-	Limit:  %d
-	Skip:   %d
-	------------------
-	------ Meta ------
-	%v
-	------------------
-	` , r.Filter, r.Projection, r.Sort, r.Limit, r.Skip, r.Meta)
+	return r.Render(FormatText)
+}
+
+// Pipeline - Returns the QResult as MongoDB aggregation pipeline stages. Stages are only included when they have a value.
+func (r *QResult) Pipeline() []bson.M {
+	pipeline := r.filterStages()
+	if len(r.Sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": r.Sort})
+	}
+	if r.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": r.Skip})
+	}
+	if r.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": r.Limit})
+	}
+	if len(r.Projection) > 0 {
+		pipeline = append(pipeline, bson.M{"$project": r.Projection})
+	}
+
+	return pipeline
+}
+// filterStages - Returns the search, filter, and computed field stages of the QResult pipeline
+func (r *QResult) filterStages() []bson.M {
+	pipeline := []bson.M{}
+	if len(r.VectorSearch) > 0 {
+		// $vectorSearch must be the first stage, so it applies the Filter as its pre-filter
+		stage := make(bson.M, len(r.VectorSearch)+1)
+		for k, v := range r.VectorSearch {
+			stage[k] = v
+		}
+		if len(r.Filter) > 0 {
+			stage["filter"] = r.Filter
+		}
+		pipeline = append(pipeline, bson.M{"$vectorSearch": stage})
+		if r.MinScore > 0 {
+			pipeline = append(pipeline, bson.M{"$match": bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$meta": "vectorSearchScore"}, r.MinScore}}}})
+		}
+	} else {
+		if len(r.Search) > 0 {
+			// $search must be the first stage
+			pipeline = append(pipeline, bson.M{"$search": r.Search})
+			if r.MinScore > 0 {
+				pipeline = append(pipeline, bson.M{"$match": bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$meta": "searchScore"}, r.MinScore}}}})
+			}
+		}
+		if len(r.Filter) > 0 {
+			pipeline = append(pipeline, bson.M{"$match": r.Filter})
+		}
+	}
+	if len(r.AddFields) > 0 {
+		pipeline = append(pipeline, bson.M{"$addFields": r.AddFields})
+	}
+	return pipeline
 }
 
 type QType int
@@ -126,10 +501,54 @@ const QFloat QType = 2
 const QBool QType = 3
 // QDateTime - Allows query values to be processed as datetimes using formats added with the UseTimeLayout method. If one or more formats are not provided then time.RFC3339 is used. Does not apply to QResult if the date is invalid.
 const QDateTime QType = 4
+
+// LayoutUnixMillis - Time layout for UseTimeLayout that parses values as the number of milliseconds since the Unix epoch
+const LayoutUnixMillis string = "unixmillis"
 // QObjectID - Allows query values to be processed as MongoDB ObjectIDs. Does not apply to QResult if the value is not a valid ObjectID.
 const QObjectID QType = 5
+// QIP - Allows query values to be processed as IP addresses or CIDR ranges, like 10.0.0.0/8, stored in the form set with UseIPStorage. Does not apply to QResult if the value is not a valid address or range.
+const QIP QType = 6
+// QSemver - Allows query values to be processed as semantic versions, like 1.2.3, stored in the form set with UseSemverStorage. Does not apply to QResult if the value is not a valid version.
+const QSemver QType = 7
+// QGeo - Allows query values to be processed as GeoJSON locations with the near: and within: operators. Only near:, within:, exists:, and emptyarray: can be used with the field. Does not apply to QResult if the coordinates or shape are invalid.
+const QGeo QType = 8
+// QDecimal - Allows query values to be processed as MongoDB Decimal128 numbers, like 19.99, so high-precision fields such as monetary amounts can be compared without float rounding. Does not apply to QResult if parsing fails.
+const QDecimal QType = 9
+// QTimestamp - Allows query values to be processed as BSON timestamps, like those in the oplog. Comparison operators take seconds since the Unix epoch optionally followed by the increment, like gte:1700000000,5, and list members are seconds with an increment of 0. Does not apply to QResult if parsing fails.
+const QTimestamp QType = 10
+// QCustom - Allows query values to be processed by the function set with UseParser, so applications can filter values the other types cannot parse, like enums stored as integers. Does not apply to QResult if the parser returns an error.
+const QCustom QType = 11
+
+// QPolicy - How a field handles values that cannot be parsed
+type QPolicy int
+// PolicyDropClause - Drops the values that cannot be parsed and keeps the rest of the field's filter. QFields use this policy by default.
+const PolicyDropClause QPolicy = 0
+// PolicyDropField - Drops the field's entire filter, and adds a warning to the QResult, if any value cannot be parsed
+const PolicyDropField QPolicy = 1
+// PolicyFailRequest - Returns an error wrapping ErrValueNotValid if any value cannot be parsed
+const PolicyFailRequest QPolicy = 2
 
-// QField - Query field definition. Key and Aliases cannot be empty or use any of the following reserved values: 'qlmt', 'qskp', 'qsrt', 'qprj'. If provided, the Default method should return a valid MongoDB filter parameter.
+// QMatchPrecedence - How a field handles eq: combined with like:, slike:, or elike:
+type QMatchPrecedence int
+// MatchCombined - Both the exact and the like clauses are applied, so documents must match all of them. QFields use this precedence by default.
+const MatchCombined QMatchPrecedence = 0
+// MatchExclusive - Combining eq: with a like operator is invalid. The like clauses are handled by the field's failure Policy, so they are dropped by PolicyDropClause.
+const MatchExclusive QMatchPrecedence = 1
+// MatchPreferExact - The like clauses are dropped when eq: is used
+const MatchPreferExact QMatchPrecedence = 2
+// MatchPreferSearch - The eq: clause is dropped when a like operator is used
+const MatchPreferSearch QMatchPrecedence = 3
+
+// QMergePolicy - How a field handles values sent with more than one of its key and aliases, like myObjectID=a&id=b
+type QMergePolicy int
+// MergePreferKey - The value of the key, or of the first alias with a value, is used and each other value is ignored with a warning. QFields use this policy by default.
+const MergePreferKey QMergePolicy = 0
+// MergeCombine - The values are joined in key and alias order as if they were sent as one value, so myInt=gte:1&n=lte:5 is the same as myInt=gte:1,lte:5
+const MergeCombine QMergePolicy = 1
+// MergeReject - Returns an error wrapping ErrValueConflict
+const MergeReject QMergePolicy = 2
+
+// QField - Query field definition. Key and Aliases cannot be empty or use any of the following reserved values, whether or not the feature using them is enabled: 'lmt', 'skp', 'srt', 'prj', 'unl', 'ndf', 'tpl', 'vec', 'sch', 'hlt', 'msc', 'fld', 'or', 'nor'. If provided, the Default method should return a valid MongoDB filter parameter.
 type QField struct {
 	Type QType // The data type expected when parsing the values of query parameter values
 	Key string // The target parameter in the request query string - supports dot notation for nested fields
@@ -139,148 +558,406 @@ type QField struct {
 	IsSortable bool // If true, this QField can be used for sorting
 	IsMeta bool // If true, this QFieeld will be used as a meta field
 	HasDefaultFunc bool // If true, the Default function will be used if a the field is missing/is invalid
+	IsNotFilterable bool // If true, this QField can only be used for sorts and projections and will never appear in the Filter
+	Location *time.Location // Time zone used for QDateTime values that do not include an offset - UTC is used if nil
+	TimeLayouts []string // Layouts tried in order when parsing QDateTime values - time.RFC3339 is used if empty
+	Visibility func(ctx context.Context) bson.M // Function that returns mandatory conditions added to the Filter whenever this field is used in the Filter
+	IsPII bool // If true, this QField contains personally identifiable information and is excluded from projections unless the processor's PII grant allows it
+	Decoder QDecoder // Function used by QExecutor to convert this field's document values to client friendly values
+	Coercion []QType // Types tried in order when parsing values - used instead of Type when not empty
+	Validation string // Tag evaluated by the processor's tag validator on each raw value sent by the client, like "email" or "uuid4" - values are validated as strings
+	DBKey string // Document path used in the Filter, Projection, and Sort when it differs from Key - supports dot notation for nested fields
+	IPStorage QIPStorage // How QIP addresses are stored in documents
+	SemverStorage QSemverStorage // How QSemver versions are stored in documents
+	Policy QPolicy // How values that cannot be parsed are handled
+	MatchPrecedence QMatchPrecedence // How eq: combined with like:, slike:, or elike: is handled
+	MergePolicy QMergePolicy // How values sent with more than one of the key and aliases are handled
+	IsAllOrNothing bool // If true, in:, nin:, and all: lists are dropped when any member cannot be parsed
+	IsFoldable bool // If true, clients may use fld to fold the case of this QString field's values
+	IsRegexAllowed bool // If true, clients may use re: to filter this QString field with a regular expression
+	RegexLimits QRegexLimits // Limits on the patterns sent with re:
+	Parser func(raw string) (interface{}, error) // Function that parses each value of a QCustom field
+	fold func(string) string // Case folding applied to each value of the current query - values are not folded when nil
+	features QFeatures // Grammar features of the processor parsing the current query
+	now time.Time // Time the current query is being processed - relative datetimes are offsets from it
+	typed qvalueParser // Parser of a QTypedField's values - the parser of the field's Type is used when nil
+	isMergeSet bool // If true, the merge policy was set with UseMergePolicy, so Strict processors leave values of more than one of the key and aliases to it
+	Cardinality QCardinality // How many distinct values the field has - used by Lint
+	Interceptor func(op string, value interface{}) (interface{}, error) // Function called with each operator clause as it is built - may replace the value, veto the clause by returning nil, or reject the query by returning an error
+	ActiveWhen []string // Query parameters that activate the Default function and Interceptor - they are always active when empty
+}
+// parseTime - Parses a QDateTime value in the field's Location
+func (f *QField) parseTime(v string) (time.Time, error) {
+	loc := f.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if f.features.EnableRelativeDates && strings.HasPrefix(v, relnow) {
+		return f.parseRelativeTime(v)
+	}
+	if len(f.TimeLayouts) == 0 {
+		return time.ParseInLocation(time.RFC3339, v, loc)
+	}
+	var err error
+	for _, layout := range f.TimeLayouts {
+		if layout == LayoutUnixMillis {
+			var ms int64
+			if ms, err = strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Unix(0, ms*int64(time.Millisecond)).In(loc), nil
+			}
+			continue
+		}
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+// parseValue - Parses a single value as the field's Type, or as the first type in the field's coercion chain that can parse it
+func (f *QField) parseValue(v string) (interface{}, error) {
+	if len(f.Coercion) > 0 {
+		var err error
+		for _, t := range f.Coercion {
+			c := *f
+			c.Type = t
+			c.Coercion = nil
+			c.typed = nil
+			var value interface{}
+			if value, err = c.parseValue(v); err == nil {
+				return value, nil
+			}
+		}
+		return nil, err
+	}
+	if parser, ok := f.valueParser(); ok {
+		return parser.value(f, v)
+	}
+	return v, nil
 }
-// ApplyFilter - Processes the qvalue as the specified Type and applies the result to the provided out QResult.
+// ApplyFilter - Processes the qvalue as the specified Type and applies the result to the provided out QResult. Nothing is applied if the field's interceptor returns an error.
 func (f *QField) ApplyFilter(qvalue string, out *QResult) {
-	opValueMap := toOpValueMap(qvalue, f.Type)
-	result := bson.M{}
-	nfilters := 0
-	for op, values := range opValueMap {
+	f.applyFilter(qvalue, out, SyntaxLatest)
+}
+// Parse - Parses the qvalue as the field's Type into clauses, in the order their operators first appear, passing each clause through the field's interceptor. Values that cannot be parsed, and operators that do not apply to the field's Type, are handled by the field's failure Policy.
+func (f *QField) Parse(qvalue string) ([]QClause, error) {
+	clauses, _, _, err := f.parse(qvalue, SyntaxLatest)
+	return clauses, err
+}
+// parse - Parses the qvalue as the field's Type into clauses using the provided syntax version, and counts the values that could and could not be parsed. If the field was dropped by its failure policy, the reason is returned as dropped.
+func (f *QField) parse(qvalue string, syntax QSyntax) (clauses []QClause, counts QValueCounts, dropped error, err error) {
+	ops := []string{}
+	opValueMap := make(map[string][]string)
+	for _, c := range parseQValue(qvalue, syntax).Clauses {
+		op := canonicalOp(c.Op)
+		if _, ok := opValueMap[op]; !ok {
+			ops = append(ops, op)
+		}
+		opValueMap[op] = append(opValueMap[op], c.Values...)
+	}
+	if f.fold != nil && f.Type == QString {
+		for _, op := range ops {
+			for i, v := range opValueMap[op] {
+				opValueMap[op][i] = f.fold(v)
+			}
+		}
+	}
+	clauses = make([]QClause, 0, len(ops))
+	var failure error // first value that could not be parsed - handled by the field's failure policy
+	for _, op := range ops {
+		counts.Valid += len(opValueMap[op])
+	}
+	// invalid - Records a value that could not be parsed for the operator
+	invalid := func(op string, v string) {
+		if counts.Valid > 0 {
+			counts.Valid--
+		}
+		counts.Invalid++
+		if failure == nil {
+			failure = fmt.Errorf("%w: %q for operator %q on field %q", ErrValueNotValid, v, op, f.Key)
+		}
+	}
+	negation := "" // prefix of the operator of the clauses being added - not: when the operator is negated
+	// add - Adds a clause, passing it through the field's interceptor first
+	add := func(op string, raw []string, value interface{}) error {
+		op = negation + op
+		if f.Interceptor != nil {
+			v, err := f.Interceptor(op, value)
+			if err != nil {
+				return fmt.Errorf("field %q operator %q rejected: %w", f.Key, op, err)
+			}
+			if v == nil {
+				// clause vetoed by the interceptor
+				return nil
+			}
+			value = v
+		}
+		clauses = append(clauses, QClause{Op: op, Values: raw, Value: value})
+		return nil
+	}
+	// allOrNothing - Drops the clauses of a list operator if any of its members could not be parsed
+	allOrNothing := func(op string, values []string, before int, invalidBefore int) {
+		if !f.IsAllOrNothing || counts.Invalid == invalidBefore || (op != in && op != nin && op != all) {
+			return
+		}
+		clauses = clauses[:before]
+		counts.Valid -= len(values) - (counts.Invalid - invalidBefore)
+		counts.Invalid = invalidBefore + len(values)
+	}
+	for _, op := range ops {
+		values := opValueMap[op]
+		before, invalidBefore := len(clauses), counts.Invalid
+		var err error
+		negation = ""
+		if base, ok := negatedOp(op); ok {
+			if f.Type == QIP || f.Type == QSemver {
+				// addresses and versions can become more than one clause, which cannot be negated one at a time
+				invalid(op, strings.Join(values, ","))
+				continue
+			}
+			negation, op = not, base
+		}
+		if f.Type == QIP && len(f.Coercion) == 0 && op != emptyarray && op != exists {
+			if err = f.ipClauses(op, values, add, invalid); err != nil {
+				return nil, counts, nil, err
+			}
+			allOrNothing(op, values, before, invalidBefore)
+			continue
+		}
+		if f.Type == QGeo && len(f.Coercion) == 0 && op != near && op != within && op != emptyarray && op != exists {
+			// locations are only compared with shapes and distances
+			invalid(op, strings.Join(values, ","))
+			continue
+		}
 		switch op {
 		case eq, ne, gt, gte, lt, lte:
-			if f.Type == QString {
-				nfilters++
+			if f.features.EnableKeywords && (op == eq || op == ne) && len(values) == 1 && values[0] == "null" {
+				err = add(op, values, primitive.Null{})
+				break
+			}
+			if f.Type == QString && len(f.Coercion) == 0 {
 				// rejoin split values to use literal qvalue in query
-				result[toMOp(op)] = strings.Join(values, ",")
-				continue
+				err = add(op, values, strings.Join(values, ","))
+				break
 			}
-			for _, v := range values {
-				switch f.Type {
-				case QInt:
-					i, err := strconv.ParseInt(v, 10, 64)
-					if err == nil {
-						nfilters++
-						result[toMOp(op)] = i
-					}
-				case QFloat:
-					flt, err := strconv.ParseFloat(v, 64)
-					if err == nil {
-						nfilters++
-						result[toMOp(op)] = flt
-					}
-				case QBool:
-					b, err := strconv.ParseBool(v)
-					if err == nil {
-						nfilters++
-						result[toMOp(op)] = b
-					}
-				case QDateTime:
-					d, err := time.Parse(time.RFC3339, v)
-					if err == nil {
-						nfilters++
-						result[toMOp(op)] = primitive.NewDateTimeFromTime(d)
-					}
-				case QObjectID:
-					id, err := primitive.ObjectIDFromHex(v)
-					if err == nil {
-						nfilters++
-						result[toMOp(op)] = id
-					}
+			if f.Type == QTimestamp && len(f.Coercion) == 0 {
+				// the increment follows the seconds after a , so the values are a single timestamp
+				if ts, perr := parseTimestamp(strings.Join(values, ",")); perr == nil {
+					err = add(op, values, ts)
+				} else {
+					invalid(op, strings.Join(values, ","))
 				}
+				break
 			}
-		case in, nin, all:
-			switch f.Type {
-			case QString:
-				nfilters++
-				result[toMOp(op)] = values
-			case QInt:
-				vlist := []int64{}
-				for _, v := range values {
-					i, err := strconv.ParseInt(v, 10, 64)
-					if err == nil {
-						vlist = append(vlist, i)
-					}
-				}
-				if len(vlist) > 0 {
-					nfilters++
-					result[toMOp(op)] = vlist
-				}
-			case QFloat:
-				vlist := []float64{}
-				for _, v := range values {
-					flt, err := strconv.ParseFloat(v, 64)
-					if err == nil {
-						vlist = append(vlist, flt)
-					}
-				}
-				if len(vlist) > 0 {
-					nfilters++
-					result[toMOp(op)] = vlist
-				}
-			case QBool:
-				vlist := []bool{}
-				for _, v := range values {
-					b, err := strconv.ParseBool(v)
-					if err == nil {
-						vlist = append(vlist, b)
-					}
-				}
-				if len(vlist) > 0 {
-					nfilters++
-					result[toMOp(op)] = vlist
+			if f.Type == QDateTime && len(f.Coercion) == 0 && len(values) > 1 {
+				// layouts like time.RFC1123 contain a , so the values may be a single datetime
+				if d, perr := f.parseTime(strings.Join(values, ",")); perr == nil {
+					err = add(op, values, primitive.NewDateTimeFromTime(d))
+					break
 				}
-			case QDateTime:
-				vlist := []primitive.DateTime{}
-				for _, v := range values {
-					d, err := time.Parse(time.RFC3339, v)
-					if err == nil {
-						vlist = append(vlist, primitive.NewDateTimeFromTime(d))
+			}
+			for _, v := range values {
+				if value, perr := f.parseValue(v); perr == nil {
+					if err = add(op, []string{v}, value); err != nil {
+						break
 					}
+				} else {
+					invalid(op, v)
 				}
-				if len(vlist) > 0 {
-					nfilters++
-					result[toMOp(op)] = vlist
-				}
-			case QObjectID:
-				vlist := []primitive.ObjectID{}
+			}
+		case in, nin, all:
+			if len(f.Coercion) > 0 {
+				// members may parse to different types so they are kept in a heterogeneous array
+				vlist := bson.A{}
 				for _, v := range values {
-					id, err := primitive.ObjectIDFromHex(v)
-					if err == nil {
-						vlist = append(vlist, id)
+					if value, perr := f.parseValue(v); perr == nil {
+						vlist = append(vlist, value)
+					} else {
+						invalid(op, v)
 					}
 				}
 				if len(vlist) > 0 {
-					nfilters++
-					result[toMOp(op)] = vlist
+					err = add(op, values, vlist)
 				}
+				break
+			}
+			var vlist interface{}
+			if parser, ok := f.valueParser(); ok {
+				vlist = parser.list(f, op, values, invalid)
+			}
+			if vlist != nil {
+				err = add(op, values, vlist)
 			}
 		case like:
-			switch f.Type {
-			case QString:
-				nfilters++
-				result["$regex"] = regexp.QuoteMeta(strings.Join(values, ","))
-				result["$options"] = "i"
+			if f.Type == QString {
+				err = add(op, values, regexp.QuoteMeta(strings.Join(values, ",")))
+			} else {
+				invalid(op, strings.Join(values, ","))
 			}
 		case slike:
-			switch f.Type {
-			case QString:
-				nfilters++
-				result["$regex"] = "^" + regexp.QuoteMeta(strings.Join(values, ","))
-				result["$options"] = "i"
+			if f.Type == QString {
+				err = add(op, values, "^" + regexp.QuoteMeta(strings.Join(values, ",")))
+			} else {
+				invalid(op, strings.Join(values, ","))
+			}
+		case re:
+			pattern := strings.Join(values, ",")
+			if f.Type != QString || !f.IsRegexAllowed || len(f.Coercion) > 0 {
+				invalid(op, pattern)
+			} else if perr := f.RegexLimits.checkPattern(pattern); perr != nil {
+				invalid(op, pattern)
+			} else {
+				err = add(op, values, pattern)
+			}
+		case near:
+			if f.Type != QGeo || len(f.Coercion) > 0 {
+				invalid(op, strings.Join(values, ","))
+			} else if point, perr := parseNear(values); perr != nil {
+				invalid(op, strings.Join(values, ","))
+			} else {
+				err = add(op, values, point)
+			}
+		case within:
+			// shapes are comma separated coordinates so the values are rejoined
+			shape := strings.Join(values, ",")
+			if f.Type != QGeo || len(f.Coercion) > 0 {
+				invalid(op, shape)
+			} else if geometry, perr := parseWithin(shape); perr != nil {
+				invalid(op, shape)
+			} else {
+				err = add(op, values, geometry)
+			}
+		case emptyarray:
+			// the operator only matches arrays with no elements, so values are not allowed
+			if v := strings.Join(values, ","); v != "" {
+				invalid(op, v)
+			} else {
+				err = add(op, values, bson.A{})
+			}
+		case between:
+			if len(values) != 2 || len(f.Coercion) > 0 || (f.Type != QInt && f.Type != QFloat && f.Type != QDecimal && f.Type != QDateTime && f.Type != QObjectID && f.Type != QCustom) {
+				invalid(op, strings.Join(values, ","))
+				break
+			}
+			lower, lerr := f.parseValue(values[0])
+			upper, uerr := f.parseValue(values[1])
+			if lerr != nil || uerr != nil {
+				invalid(op, strings.Join(values, ","))
+				break
+			}
+			// the range is applied as its bounds so it is handled like any other range
+			if err = add(gte, values[:1], lower); err == nil {
+				err = add(lte, values[1:], upper)
+			}
+		case exists:
+			// presence is the same for every field type, so the value is always a bool
+			if b, perr := strconv.ParseBool(strings.Join(values, ",")); perr == nil {
+				err = add(op, values, b)
+			} else {
+				invalid(op, strings.Join(values, ","))
+			}
+		case bitsallset, bitsanyset, bitsallclear:
+			if f.Type != QInt || len(f.Coercion) > 0 {
+				invalid(op, strings.Join(values, ","))
+				break
+			}
+			// a single value is a bitmask and multiple values are bit positions
+			bits := []int64{}
+			for _, v := range values {
+				if b, perr := strconv.ParseInt(v, 10, 64); perr == nil && b >= 0 {
+					bits = append(bits, b)
+				} else {
+					invalid(op, v)
+				}
+			}
+			if len(values) == 1 && len(bits) == 1 {
+				err = add(op, values, bits[0])
+			} else if len(bits) > 0 {
+				err = add(op, values, bits)
 			}
 		case elike:
-			switch f.Type {
-			case QString:
-				nfilters++
-				result["$regex"] = regexp.QuoteMeta(strings.Join(values, ",")) + "$"
-				result["$options"] = "i"
+			if f.Type == QString {
+				err = add(op, values, regexp.QuoteMeta(strings.Join(values, ",")) + "$")
+			} else {
+				invalid(op, strings.Join(values, ","))
 			}
 		}
+		if err != nil {
+			return nil, counts, nil, err
+		}
+		allOrNothing(op, values, before, invalidBefore)
+	}
+	if f.MatchPrecedence != MatchCombined {
+		clauses = f.resolveMatchPrecedence(clauses, invalid)
+	}
+	if failure != nil {
+		switch f.Policy {
+		case PolicyDropField:
+			return nil, counts, failure, nil
+		case PolicyFailRequest:
+			return nil, counts, nil, failure
+		}
+	}
+	return clauses, counts, nil, nil
+}
+// resolveMatchPrecedence - Applies the field's match precedence to clauses that combine eq: with like operators
+func (f *QField) resolveMatchPrecedence(clauses []QClause, invalid func(op string, v string)) []QClause {
+	isSearch := func(op string) bool {
+		return op == like || op == slike || op == elike
+	}
+	exact, search := false, false
+	for _, c := range clauses {
+		exact = exact || c.Op == eq
+		search = search || isSearch(c.Op)
+	}
+	if !exact || !search {
+		return clauses
+	}
+	kept := make([]QClause, 0, len(clauses))
+	for _, c := range clauses {
+		switch {
+		case isSearch(c.Op) && f.MatchPrecedence == MatchExclusive:
+			invalid(c.Op, strings.Join(c.Values, ","))
+		case isSearch(c.Op) && f.MatchPrecedence == MatchPreferExact:
+		case c.Op == eq && f.MatchPrecedence == MatchPreferSearch:
+		default:
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+// applyFilter - Processes the qvalue as the specified Type using the provided syntax version and applies the result to the provided out QResult
+func (f *QField) applyFilter(qvalue string, out *QResult, syntax QSyntax) error {
+	clauses, counts, dropped, err := f.parse(qvalue, syntax)
+	if err != nil {
+		return err
+	}
+	if out.ValueCounts == nil {
+		out.ValueCounts = make(map[string]QValueCounts)
 	}
-	
-	if nfilters > 0 {
-		out.Filter[f.Key] = result
+	out.ValueCounts[f.Key] = counts
+	if dropped != nil {
+		out.warn(f.Key, qvalue, fmt.Sprintf("filter ignored - %v", dropped))
+	}
+	if len(clauses) == 0 {
+		return nil
+	}
+	cond, err := MongoBackend{}.Condition(f.dbKey(), clauses)
+	if err != nil {
+		return err
+	}
+	for k, v := range cond.(bson.M) {
+		if k == "$and" {
+			// conditions on more than one document field, like versions stored as fields
+			for _, c := range v.(bson.A) {
+				out.and(c.(bson.M))
+			}
+			continue
+		}
+		out.Filter[k] = v
 	}
+	out.setClauses(f.dbKey(), clauses)
+	return nil
 }
 // UseDefault - Sets the Default method to the provided function. Returns caller for chaining.
 func (f *QField) UseDefault(fn func() string) *QField{
@@ -289,34 +966,147 @@ func (f *QField) UseDefault(fn func() string) *QField{
 	return f
 }
 
-// UseAliases - Adds one or more aliases to this field. Returns caller for chaining.
-func (f *QField) UseAliases(alias ...string) *QField {
-	f.Aliases = append(f.Aliases, alias...)
+// UseDefaultClause - Sets the Default method to a function built from the provided clause function, which returns an operator (e.g. "gte" or "gte:") and a value. Values are formatted with FormatClause so defaults can be constructed programmatically instead of hand-writing MongoQS syntax. Returns caller for chaining.
+func (f *QField) UseDefaultClause(fn func() (string, interface{})) *QField {
+	// run the clause function once so unknown operators are reported at startup
+	if op, _ := fn(); !isOp(op) {
+		log.Fatal(fmt.Sprintf("Field %q default clause is using an unknown operator %q\n", f.Key, op))
+	}
+	return f.UseDefault(func() string {
+		return FormatClause(fn())
+	})
+}
+
+// UseActivation - Sets the query parameters that activate the field's Default function and Interceptor, like only defaulting a date range when groupBy is used. They are only used when the query has a value for at least one of the parameters, which may be any parameter, including the keys of Meta fields and reserved keys like srt. Returns caller for chaining.
+func (f *QField) UseActivation(keys ...string) *QField {
+	f.ActiveWhen = append(f.ActiveWhen, keys...)
 	return f
 }
-// Projectable - Allows field to be used in projections. Returns caller for chaining.
-func (f *QField) Projectable() *QField{
-	f.IsProjectable = true
+
+// UseVisibilityFilter - Sets a function that returns additional mandatory conditions, like organization membership, that are added to the Filter with $and whenever this field appears in the Filter. The function receives the context passed to ProcessContext. Returns caller for chaining.
+func (f *QField) UseVisibilityFilter(fn func(ctx context.Context) bson.M) *QField {
+	f.Visibility = fn
 	return f
 }
-// Sortable - Allows field to be used in sorts. Returns caller for chaining.
-func (f *QField) Sortable() *QField {
-	f.IsSortable = true
+
+// UseDecoder - Sets the function QExecutor uses to convert this field's document values before they are returned, like DecodeDateTimeRFC3339 or DecodeDecimalString. Returns caller for chaining.
+func (f *QField) UseDecoder(fn QDecoder) *QField {
+	f.Decoder = fn
 	return f
 }
 
-// ParseAsMeta - Indicates that this field will not appear in the QResult Filter and will be parsed/interpreted outside of MongoQS
-func (f *QField) ParseAsMeta() *QField {
-	f.Type = QString
-	f.IsMeta = true
+// UseInterceptor - Sets a function that is called with the operator (e.g. "eq:") and parsed value of each clause as it is built. The function can return a replacement value, like mapping eq:me to the caller's ID, return nil to drop the clause, or return an error to reject the query. Returns caller for chaining.
+func (f *QField) UseInterceptor(fn func(op string, value interface{}) (interface{}, error)) *QField {
+	f.Interceptor = fn
 	return f
 }
-// ParseAsString - Indicates that this field represents a database document field that contains a string value
-func (f *QField) ParseAsString() *QField {
-	f.Type = QString
+
+// UseDBKey - Sets the document path used in the Filter, Projection, and Sort when it differs from the Key clients use, like `createdAt` stored at `meta.created`. Returns caller for chaining.
+func (f *QField) UseDBKey(path string) *QField {
+	f.DBKey = path
 	return f
 }
-// ParseAsInt - Indicates that this field represents a database document field that contains an integer value
+// dbKey - Returns the document path of the field
+func (f *QField) dbKey() string {
+	if f.DBKey != "" {
+		return f.DBKey
+	}
+	return f.Key
+}
+// UseFailurePolicy - Sets how values that cannot be parsed, or use an operator that does not apply to the field's Type, are handled. Returns caller for chaining.
+func (f *QField) UseFailurePolicy(policy QPolicy) *QField {
+	f.Policy = policy
+	return f
+}
+// Foldable - Allows clients to send fld=lower or fld=upper to fold the case of this QString field's values, so clients querying case-normalized data, like lowercased emails, do not need to know how it is stored. Returns caller for chaining.
+func (f *QField) Foldable() *QField {
+	f.IsFoldable = true
+	return f
+}
+// AllOrNothingLists - Drops the entire in:, nin:, or all: clause when any member cannot be parsed, instead of only the member, since silently ignoring a malformed ID in a lookup can return the wrong documents. Strict processors return an error wrapping ErrValueNotValid instead when any value of the field cannot be parsed. Returns caller for chaining.
+func (f *QField) AllOrNothingLists() *QField {
+	f.IsAllOrNothing = true
+	return f
+}
+// UseMatchPrecedence - Sets how eq: combined with like:, slike:, or elike: on this field is handled. Returns caller for chaining.
+func (f *QField) UseMatchPrecedence(precedence QMatchPrecedence) *QField {
+	f.MatchPrecedence = precedence
+	return f
+}
+// UseMergePolicy - Sets how values sent with more than one of the field's key and aliases are handled. Strict processors apply the policy instead of returning an error when the key and aliases are used together. Returns caller for chaining.
+func (f *QField) UseMergePolicy(policy QMergePolicy) *QField {
+	f.MergePolicy = policy
+	f.isMergeSet = true
+	return f
+}
+// UseValidation - Sets a validation tag, like "uuid4" or "email", that is evaluated by the processor's tag validator on each raw value sent by the client before it is parsed. Values are validated as strings, so numeric tags like max=100 check the length of the value, not its number. Returns caller for chaining.
+func (f *QField) UseValidation(tag string) *QField {
+	f.Validation = tag
+	return f
+}
+// UseCoercion - Sets a chain of types that are tried in order when parsing each value, for fields that store values of more than one type. The first type that can parse a value is used, so QString should be last if it is included. List operators (in:, nin:, all:) produce a heterogeneous array when members parse to different types. Returns caller for chaining.
+func (f *QField) UseCoercion(types ...QType) *QField {
+	f.Coercion = types
+	return f
+}
+
+// UseAliases - Adds one or more aliases to this field. Returns caller for chaining.
+func (f *QField) UseAliases(alias ...string) *QField {
+	f.Aliases = append(f.Aliases, alias...)
+	return f
+}
+// Projectable - Allows field to be used in projections. Returns caller for chaining.
+func (f *QField) Projectable() *QField{
+	f.IsProjectable = true
+	return f
+}
+// Sortable - Allows field to be used in sorts. Returns caller for chaining.
+func (f *QField) Sortable() *QField {
+	f.IsSortable = true
+	return f
+}
+
+// NotFilterable - Prevents the field from being used in the QResult Filter. Useful for computed or server maintained fields that should be sortable or projectable but not queryable. Returns caller for chaining.
+func (f *QField) NotFilterable() *QField {
+	f.IsNotFilterable = true
+	return f
+}
+
+// UseTimeZone - Sets the time zone used for QDateTime values that do not include an offset. Returns caller for chaining.
+func (f *QField) UseTimeZone(loc *time.Location) *QField {
+	f.Location = loc
+	return f
+}
+
+// UseTimeLayout - Sets the layouts, like "2006-01-02", time.RFC822, or LayoutUnixMillis, that are tried in order when parsing QDateTime values. Values that do not match any layout are handled by the field's failure Policy. Values without an offset are parsed in the field's time zone. Layouts with commas, like time.RFC1123, are matched against the entire value of comparison operators, so they cannot be used in lists. Include time.RFC3339 when the field's Default function uses DefaultToday, DefaultLastDays, or FormatClause, which format datetimes as RFC3339. Returns caller for chaining.
+func (f *QField) UseTimeLayout(layouts ...string) *QField {
+	for _, layout := range layouts {
+		if layout == "" {
+			log.Fatal(fmt.Sprintf("Field %q time layout cannot be an empty string\n", f.Key))
+		}
+	}
+	f.TimeLayouts = append(f.TimeLayouts, layouts...)
+	return f
+}
+
+// PII - Indicates that this field contains personally identifiable information. PII fields are excluded from every QResult Projection unless the processor's PII grant function allows the caller to see the field. Returns caller for chaining.
+func (f *QField) PII() *QField {
+	f.IsPII = true
+	return f
+}
+
+// ParseAsMeta - Indicates that this field will not appear in the QResult Filter and will be parsed/interpreted outside of MongoQS
+func (f *QField) ParseAsMeta() *QField {
+	f.Type = QString
+	f.IsMeta = true
+	return f
+}
+// ParseAsString - Indicates that this field represents a database document field that contains a string value
+func (f *QField) ParseAsString() *QField {
+	f.Type = QString
+	return f
+}
+// ParseAsInt - Indicates that this field represents a database document field that contains an integer value
 func (f *QField) ParseAsInt() *QField {
 	f.Type = QInt
 	return f
@@ -326,6 +1116,11 @@ func (f *QField) ParseAsFloat() *QField {
 	f.Type = QFloat
 	return f
 }
+// ParseAsDecimal - Indicates that this field represents a database document field that contains a Decimal128 number value
+func (f *QField) ParseAsDecimal() *QField {
+	f.Type = QDecimal
+	return f
+}
 // ParseAsBool - Indicates that this field represents a database document field that contains a boolean value
 func (f *QField) ParseAsBool() *QField {
 	f.Type = QBool
@@ -336,12 +1131,59 @@ func (f *QField) ParseAsDateTime() *QField {
 	f.Type = QDateTime
 	return f
 }
+// ParseAsTimestamp - Indicates that this field represents a database document field that contains a BSON timestamp value
+func (f *QField) ParseAsTimestamp() *QField {
+	f.Type = QTimestamp
+	return f
+}
 // ParseAsObjectID - Indicates that this field represents a database document field that contains a string value
 func (f *QField) ParseAsObjectID() *QField {
 	f.Type = QObjectID
 	return f
 }
 
+// ParseAsIP - Indicates that this field represents a database document field that contains an IP address
+func (f *QField) ParseAsIP() *QField {
+	f.Type = QIP
+	return f
+}
+// UseIPStorage - Sets how a QIP field's addresses are stored in documents - IPString (default) or IPNumeric. Returns caller for chaining.
+func (f *QField) UseIPStorage(storage QIPStorage) *QField {
+	f.IPStorage = storage
+	return f
+}
+
+// ParseAsSemver - Indicates that this field represents a database document field that contains a semantic version
+func (f *QField) ParseAsSemver() *QField {
+	f.Type = QSemver
+	return f
+}
+// UseSemverStorage - Sets how a QSemver field's versions are stored in documents - SemverKey (default) or SemverFields. Returns caller for chaining.
+func (f *QField) UseSemverStorage(storage QSemverStorage) *QField {
+	f.SemverStorage = storage
+	return f
+}
+
+// ParseAsGeo - Indicates that this field represents a database document field that contains a GeoJSON location, or legacy coordinate pair, with a 2dsphere index
+func (f *QField) ParseAsGeo() *QField {
+	f.Type = QGeo
+	return f
+}
+// UseParser - Sets the function that parses each value of the field and sets the field's Type to QCustom. Values the function returns an error for are handled by the field's failure Policy. Returns caller for chaining.
+func (f *QField) UseParser(parse func(raw string) (interface{}, error)) *QField {
+	if parse == nil {
+		log.Fatal(fmt.Sprintf("Field %q parser cannot be nil\n", f.Key))
+	}
+	f.Type = QCustom
+	f.Parser = parse
+	return f
+}
+// UseCardinality - Sets how many distinct values the field has so Lint can report filters that are unlikely to be selective. Returns caller for chaining.
+func (f *QField) UseCardinality(c QCardinality) *QField {
+	f.Cardinality = c
+	return f
+}
+
 // NewQField - Returns a new Qfield with the provided key and type.
 func NewQField(key string) QField {
 	return QField{Key: key}
@@ -352,144 +1194,969 @@ func NewQResult() QResult {
 	result := QResult{}
 	result.Filter = bson.M{}
 	result.Projection = bson.M{}
-	result.Sort = bson.M{}
+	result.Sort = bson.D{}
 	result.Meta = make(map[string]string)
+	result.AddFields = bson.M{}
+	result.SortInputs = make(map[string]string)
+	result.SortFields = make(map[string]string)
+	result.Parsed = make(map[string][]QClause)
+	result.ValueCounts = make(map[string]QValueCounts)
+	result.DefaultsApplied = []string{}
 
 	return result
 }
 
-// NewQProcessor - Validates the provided QFields and returns a function that converts a URL query to a QResult.
-func NewQProcessor(fields ...QField) QueryProcessorFn {
-	// validate fields to ensures each field's Key and Aliases are not empty or using reserved values
-	for _, f := range fields {
-		switch f.Key {
-		case "":
-			log.Fatal(fmt.Sprintf("Field %q cannot be an empty string\n", f.Key))
-		case lmt, skp, srt, prj:
-			log.Fatal(fmt.Sprintf("Field %q is using a reserved key - reserved keys: %q, %q, %q, %q\n", f.Key, lmt, skp, srt, prj))
+// QProcessor - Query processor that converts URL queries to QResults using a set of QFields. Processor options are set with chainable methods and should not be changed once the processor is in use.
+type QProcessor struct {
+	fields []QField // Fields the processor will accept
+	IsStrict bool // If true, the processor returns an error for queries that are likely the result of client bugs
+	IsBestEffort bool // If true, the processor skips the parts of a query that fail and returns the QResult with a QErrors of every failure
+	computed map[string]interface{} // Map of computed projection names to aggregation expressions
+	virtual map[string]qvirtual // Map of virtual field names to their request time resolvers
+	sortPresets map[string][]string // Map of sort preset names to srt entries
+	defaultSort []string // srt entries used when the query does not sort by any key
+	defaultLimit int64 // Limit used when lmt is missing, invalid, or not greater than 0
+	unlimitedCap int64 // Limit used when unl=true - unlimited queries are not allowed when 0
+	hardCap int64 // Absolute limit that is never exceeded - not enforced when 0
+	usage *usageTracker // Usage statistics - only tracked when not nil
+	learner *learner // Undeclared query keys - only recorded when not nil
+	syntax QSyntax // Query string syntax version
+	roles func(ctx context.Context) []string // Resolves the roles of the caller from the request context
+	restrictedOps map[string][]string // Map of operators to the roles that are allowed to use them
+	piiGrant func(ctx context.Context, key string) bool // Returns true if the caller may see the PII field with the provided key
+	redact bool // If true, QResults are redacted when rendered or logged
+	features QFeatures // Grammar features the processor has opted into
+	templates map[string]qtemplate // Map of filter template names to templates
+	lists map[string]func() []string // Map of list names to functions returning the list values
+	extraSortKeys []string // Keys that may be sorted by without being QFields
+	extraProjectionKeys []string // Keys that may be projected without being QFields
+	IsDefaultSuppressible bool // If true, clients may use ndf to opt out of Default functions
+	tagValidator func(field interface{}, tag string) error // Evaluates field validation tags - validation tags are ignored when nil
+	search *qsearch // Atlas Search options - Atlas Search is not allowed when nil
+	vector *qvector // Atlas Vector Search options - vector search is not allowed when nil
+	timeseries *qtimeseries // Time series collection options - time series warnings are not added when nil
+	collections []qcollection // Collections queries are fanned out to by ProcessFederated
+	archive *qarchive // Archive routing options - TargetsArchive is never set when nil
+	shardKey []string // Keys of the fields of the collection's shard key - shard key warnings are not added when empty
+	parallelism int // Number of goroutines used to build field filters - filters are built sequentially when less than 2
+}
+
+// qtemplate - A parameterized filter template
+type qtemplate struct {
+	params []QType // Types of the template's positional parameters
+	build func(args ...interface{}) bson.M // Builds the filter conditions from the parsed arguments
+}
+
+// WithSyntax - Pins the processor to a query string syntax version so future grammar changes do not change how existing clients' query strings are parsed. Processors use SyntaxLatest by default. Returns caller for chaining.
+func (p *QProcessor) WithSyntax(syntax QSyntax) *QProcessor {
+	if syntax < SyntaxV1 || syntax > SyntaxLatest {
+		log.Fatal(fmt.Sprintf("Syntax version %d is not supported - supported versions: %d to %d\n", syntax, SyntaxV1, SyntaxLatest))
+	}
+	p.syntax = syntax
+	return p
+}
+
+// WithRoles - Sets the function used to resolve the caller's roles from the context passed to ProcessContext. Roles are used to enforce operator restrictions. Returns caller for chaining.
+func (p *QProcessor) WithRoles(fn func(ctx context.Context) []string) *QProcessor {
+	p.roles = fn
+	return p
+}
+
+// RestrictOperator - Only allows the operator (e.g. "nin" or "like:"), and its negations (e.g. "not:like:"), to be used by callers with at least one of the provided roles. Queries using a restricted operator without a matching role return an error wrapping ErrOperatorNotAllowed. Returns caller for chaining.
+func (p *QProcessor) RestrictOperator(op string, roles ...string) *QProcessor {
+	if !isOp(op) {
+		log.Fatal(fmt.Sprintf("Cannot restrict unknown operator %q\n", op))
+	}
+	if !strings.HasSuffix(op, ":") {
+		op += ":"
+	}
+	if p.restrictedOps == nil {
+		p.restrictedOps = make(map[string][]string)
+	}
+	p.restrictedOps[op] = append(p.restrictedOps[op], roles...)
+	return p
+}
+
+// WithPIIGrant - Sets the function that decides, per request, whether the caller may see a PII field. Without a grant function PII fields are always excluded from projections. Returns caller for chaining.
+func (p *QProcessor) WithPIIGrant(fn func(ctx context.Context, key string) bool) *QProcessor {
+	p.piiGrant = fn
+	return p
+}
+
+// WithTemplate - Registers a parameterized filter template that clients can invoke with tpl=<name>:<arg>,<arg>. Each argument is parsed as the QType at the same position in params, and the build function receives the parsed arguments and returns conditions that are added to the Filter with $and. Invoking an unknown template or providing invalid arguments returns an error. Returns caller for chaining.
+func (p *QProcessor) WithTemplate(name string, build func(args ...interface{}) bson.M, params ...QType) *QProcessor {
+	if name == "" || strings.Contains(name, ":") {
+		log.Fatal(fmt.Sprintf("Template name %q cannot be empty or contain ':'\n", name))
+	}
+	if p.templates == nil {
+		p.templates = make(map[string]qtemplate)
+	}
+	p.templates[name] = qtemplate{params: params, build: build}
+	return p
+}
+
+// applyTemplate - Parses a tpl value and adds the expanded template conditions to the QResult
+func (p *QProcessor) applyTemplate(qtpl string, out *QResult) error {
+	name, qargs := qtpl, ""
+	if i := strings.Index(qtpl, ":"); i >= 0 {
+		name, qargs = qtpl[:i], qtpl[i+1:]
+	}
+	t, ok := p.templates[name]
+	if !ok {
+		return fmt.Errorf("template %q does not exist", name)
+	}
+	rawargs := []string{}
+	if qargs != "" {
+		rawargs = strings.Split(qargs, ",")
+	}
+	if len(rawargs) != len(t.params) {
+		return fmt.Errorf("template %q expects %d arguments - got %d", name, len(t.params), len(rawargs))
+	}
+	args := make([]interface{}, len(rawargs))
+	for i, raw := range rawargs {
+		field := QField{Key: name, Type: t.params[i]}
+		arg, err := field.parseValue(raw)
+		if err != nil {
+			return fmt.Errorf("template %q argument %d is invalid: %w", name, i+1, err)
 		}
-		for _, a := range f.Aliases {
-			switch a {
-			case "":
-				log.Fatal(fmt.Sprintf("Field %q alias cannot be an empty string\n", f.Key))
-			case lmt, skp, srt, prj:
-				log.Fatal(fmt.Sprintf("Field %q alias %q is using a reserved key - reserved keys: %q, %q, %q, %q\n", f.Key, a, lmt, skp, srt, prj))
+		args[i] = arg
+	}
+	if cond := t.build(args...); len(cond) > 0 {
+		out.and(cond)
+	}
+	return nil
+}
+
+// WithList - Registers a server-side list that clients can reference with @<name> in place of values, like status=nin:@terminalStatuses. The function is called each time the list is referenced so the values can change at runtime. References to lists that are not registered are treated as literal values. Returns caller for chaining.
+func (p *QProcessor) WithList(name string, fn func() []string) *QProcessor {
+	if !listname.MatchString(name) {
+		log.Fatal(fmt.Sprintf("List name %q must only contain letters, numbers, and underscores\n", name))
+	}
+	if p.lists == nil {
+		p.lists = make(map[string]func() []string)
+	}
+	p.lists[name] = fn
+	return p
+}
+
+// expandLists - Replaces list references in the qvalue with the values of the referenced lists
+func (p *QProcessor) expandLists(qvalue string) string {
+	if len(p.lists) == 0 || !strings.Contains(qvalue, listref) {
+		return qvalue
+	}
+	return listregex.ReplaceAllStringFunc(qvalue, func(match string) string {
+		// keep the leading delimiter, if any, that was matched with the reference
+		i := strings.Index(match, listref)
+		fn, ok := p.lists[match[i+1:]]
+		if !ok {
+			return match
+		}
+		return match[:i] + strings.Join(fn(), ",")
+	})
+}
+
+// WithExtraSortKeys - Allows keys that are not QFields, like internally managed timestamps, to be used in sorts. Returns caller for chaining.
+func (p *QProcessor) WithExtraSortKeys(keys ...string) *QProcessor {
+	validateExtraKeys(keys)
+	p.extraSortKeys = append(p.extraSortKeys, keys...)
+	return p
+}
+
+// WithExtraProjectionKeys - Allows keys that are not QFields to be used in projections. Returns caller for chaining.
+func (p *QProcessor) WithExtraProjectionKeys(keys ...string) *QProcessor {
+	validateExtraKeys(keys)
+	p.extraProjectionKeys = append(p.extraProjectionKeys, keys...)
+	return p
+}
+
+// validateExtraKeys - Ensures extra sort and projection keys are not empty or reserved. Exits if a key is invalid.
+func validateExtraKeys(keys []string) {
+	for _, key := range keys {
+		switch {
+		case key == "":
+			log.Fatal("Extra key cannot be an empty string")
+		case isReserved(key):
+			log.Fatal(fmt.Sprintf("Extra key %q is using a reserved key - reserved keys: %q\n", key, reserved))
+		}
+	}
+}
+
+// WithTagValidator - Sets the function used to evaluate field validation tags, such as the Var method of a go-playground/validator Validate. Each raw value sent by the client for a field with a validation tag is passed to the function before it is parsed, and the query is rejected if an error is returned. Returns caller for chaining.
+func (p *QProcessor) WithTagValidator(fn func(field interface{}, tag string) error) *QProcessor {
+	p.tagValidator = fn
+	return p
+}
+
+// AllowDefaultSuppression - Allows clients to opt out of Default functions with ndf=<field>,<field> or ndf=all, for clients that genuinely need an unfiltered view. Returns caller for chaining.
+func (p *QProcessor) AllowDefaultSuppression() *QProcessor {
+	p.IsDefaultSuppressible = true
+	return p
+}
+
+// WithHardCap - Sets an absolute limit that is enforced after the client limit, default limit, and unlimited flag are applied. A QResult Limit will never be 0 (no limit) or greater than the hard cap. Returns caller for chaining.
+func (p *QProcessor) WithHardCap(n int64) *QProcessor {
+	if n <= 0 {
+		log.Fatal(fmt.Sprintf("Hard cap must be greater than 0 - got %d\n", n))
+	}
+	p.hardCap = n
+	return p
+}
+
+// WithDefaultLimit - Sets the limit used when lmt is missing, invalid, or not greater than 0. Without a default limit the QResult Limit is 0, which MongoDB treats as no limit. Returns caller for chaining.
+func (p *QProcessor) WithDefaultLimit(n int64) *QProcessor {
+	if n <= 0 {
+		log.Fatal(fmt.Sprintf("Default limit must be greater than 0 - got %d\n", n))
+	}
+	p.defaultLimit = n
+	return p
+}
+
+// AllowUnlimited - Allows clients to request all documents with unl=true. The limit is still capped at the provided value so an unlimited query can never return more documents than the server allows. Returns caller for chaining.
+func (p *QProcessor) AllowUnlimited(cap int64) *QProcessor {
+	if cap <= 0 {
+		log.Fatal(fmt.Sprintf("Unlimited cap must be greater than 0 - got %d\n", cap))
+	}
+	p.unlimitedCap = cap
+	return p
+}
+
+// WithSortPreset - Registers a named sort that clients can select with srt=<name>. Sorts are provided using srt syntax (e.g. "-score", "-createdAt") and are applied in order. Preset sorts are controlled server-side so they may refer to keys that are not QFields. Selecting a preset in descending order (srt=-<name>) reverses each of its sorts. Returns caller for chaining.
+func (p *QProcessor) WithSortPreset(name string, sorts ...string) *QProcessor {
+	switch {
+	case name == "":
+		log.Fatal("Sort preset name cannot be an empty string")
+	case isReserved(name):
+		log.Fatal(fmt.Sprintf("Sort preset %q is using a reserved key - reserved keys: %q\n", name, reserved))
+	}
+	if len(sorts) == 0 {
+		log.Fatal(fmt.Sprintf("Sort preset %q must have at least one sort\n", name))
+	}
+	if p.sortPresets == nil {
+		p.sortPresets = make(map[string][]string)
+	}
+	p.sortPresets[name] = sorts
+	return p
+}
+
+// WithDefaultSort - Sets the sorts, provided using srt syntax (e.g. "-createdAt"), that are applied in order when the query does not sort by any key, so paged results have a stable order. Like sort presets, default sorts are controlled server-side so they may refer to keys that are not QFields. Returns caller for chaining.
+func (p *QProcessor) WithDefaultSort(sorts ...string) *QProcessor {
+	if len(sorts) == 0 {
+		log.Fatal("Default sort must have at least one sort")
+	}
+	p.defaultSort = sorts
+	return p
+}
+
+// WithComputedProjection - Registers a computed field that can be included in projections. When included, the name and aggregation expression are added to the QResult AddFields, which is applied as an $addFields stage by QResult.Pipeline. Returns caller for chaining.
+func (p *QProcessor) WithComputedProjection(name string, expr interface{}) *QProcessor {
+	switch {
+	case name == "":
+		log.Fatal("Computed projection name cannot be an empty string")
+	case isReserved(name):
+		log.Fatal(fmt.Sprintf("Computed projection %q is using a reserved key - reserved keys: %q\n", name, reserved))
+	}
+	for _, f := range p.fields {
+		if f.Key == name {
+			log.Fatal(fmt.Sprintf("Computed projection %q is already used as a field key\n", name))
+		}
+	}
+	if _, ok := p.virtual[name]; ok {
+		log.Fatal(fmt.Sprintf("Computed projection %q is already used as a virtual field\n", name))
+	}
+	if p.computed == nil {
+		p.computed = make(map[string]interface{})
+	}
+	p.computed[name] = expr
+	return p
+}
+
+// Strict - Makes the processor return an error when a field key, or any of its aliases, appears more than once in a query. Fields with a merge policy set with UseMergePolicy may use the key and aliases together, and only return an error when one of them is repeated. Returns caller for chaining.
+func (p *QProcessor) Strict() *QProcessor {
+	p.IsStrict = true
+	return p
+}
+
+// BestEffort - Makes the processor skip the fields, templates, and search parameters that fail instead of abandoning the query. The QResult built from the rest of the query is returned with a QErrors listing every failure, so endpoints can serve partially filtered results while reporting the problems. Cancelled contexts, panics, and filters that are not safe still return an empty QResult. Returns caller for chaining.
+func (p *QProcessor) BestEffort() *QProcessor {
+	p.IsBestEffort = true
+	return p
+}
+
+// fieldByKey - Returns the processor's field with the provided key, or nil if there is none
+func (p *QProcessor) fieldByKey(key string) *QField {
+	for i := range p.fields {
+		if p.fields[i].Key == key {
+			return &p.fields[i]
+		}
+	}
+	return nil
+}
+
+// Derive - Returns a new processor with the same options and fields as the caller, where each override replaces the field with the same key and overrides with new keys are added. Options set on the derived processor do not affect the caller, so one field pool can be shared across trust boundaries, like a public API with a stricter hard cap than an admin API. Usage statistics and learned keys are not shared.
+func (p *QProcessor) Derive(overrides ...QField) *QProcessor {
+	fields := make([]QField, len(p.fields))
+	copy(fields, p.fields)
+	for _, o := range overrides {
+		replaced := false
+		for i, f := range fields {
+			if f.Key == o.Key {
+				fields[i] = o
+				replaced = true
+				break
 			}
 		}
-		if f.IsMeta {
-			if f.Type != QString {
-				// Although meta fields are not processed the same as other fields, and having the Type set to something other than QString will not break the processor,
-				// developers should be told they are attempting to do something that will not work as expected since meta fields will always be parsed as QString
-				log.Fatal(fmt.Sprintf("Field %q is a meta field and can only be parsed as type QString", f.Key))
+		if !replaced {
+			fields = append(fields, o)
+		}
+	}
+	validateFields(fields)
+	derived := *p
+	derived.fields = fields
+	derived.computed = make(map[string]interface{}, len(p.computed))
+	for k, v := range p.computed {
+		derived.computed[k] = v
+	}
+	derived.virtual = make(map[string]qvirtual, len(p.virtual))
+	for k, v := range p.virtual {
+		derived.virtual[k] = v
+	}
+	derived.sortPresets = make(map[string][]string, len(p.sortPresets))
+	for k, v := range p.sortPresets {
+		derived.sortPresets[k] = v
+	}
+	derived.restrictedOps = make(map[string][]string, len(p.restrictedOps))
+	for k, v := range p.restrictedOps {
+		derived.restrictedOps[k] = append([]string{}, v...)
+	}
+	derived.templates = make(map[string]qtemplate, len(p.templates))
+	for k, v := range p.templates {
+		derived.templates[k] = v
+	}
+	derived.lists = make(map[string]func() []string, len(p.lists))
+	for k, v := range p.lists {
+		derived.lists[k] = v
+	}
+	derived.extraSortKeys = append([]string{}, p.extraSortKeys...)
+	derived.defaultSort = append([]string{}, p.defaultSort...)
+	derived.extraProjectionKeys = append([]string{}, p.extraProjectionKeys...)
+	derived.usage = nil
+	if p.usage != nil {
+		derived.TrackUsage()
+	}
+	derived.learner = nil
+	if p.learner != nil {
+		derived.Learn()
+	}
+	return &derived
+}
+
+// Process - Converts the provided URL query to a QResult.
+func (p *QProcessor) Process(query url.Values) (QResult, error) {
+	return p.ProcessContext(context.Background(), query)
+}
+
+// ProcessContext - Converts the provided URL query to a QResult. The context is passed to processor callbacks, like the role resolver, so request scoped values can be used during processing. The context is checked between fields, and its error is returned if it is cancelled or its deadline is exceeded. Panics, including panics in callbacks, are recovered and returned as a QPanicError.
+func (p *QProcessor) ProcessContext(ctx context.Context, query url.Values) (result QResult, err error) {
+	defer func() {
+		// a malformed query, or a panicking callback, must never take down the caller
+		if v := recover(); v != nil {
+			result, err = QResult{}, &QPanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return p.processContext(ctx, query)
+}
+
+// checkValue - Returns an error if the qvalue sent by the client for the field uses an operator the caller's roles do not allow, or fails the field's validation tag. The caller's roles are resolved the first time an operator is restricted and kept in roles.
+func (p *QProcessor) checkValue(ctx context.Context, field QField, source string, qvalue string, roles *[]string) error {
+	if len(p.restrictedOps) > 0 && !field.IsMeta {
+		if *roles == nil {
+			*roles = []string{}
+			if p.roles != nil {
+				*roles = p.roles(ctx)
 			}
-			if f.IsSortable || f.IsProjectable {
-				// Although meta fields are not processed the same as other fields, and having the IsProjectable and IsSortable flags set will not break the processor,
-				// developers should be told they are attempting to do something that will not work as expected since meta fields will never appear in the QResult Sort property
-				log.Fatal(fmt.Sprintf("Field %q is a meta field and will never appear in Projection or Sort - modify %q to not be projectable or sortable\n", f.Key, f.Key))
+		}
+		for op := range toOpValueMap(qvalue, field.Type, p.syntax) {
+			// negated operators are restricted with the operator they negate
+			base, _ := negatedOp(op)
+			allowed, ok := p.restrictedOps[op]
+			if !ok {
+				allowed, ok = p.restrictedOps[base]
+			}
+			if ok && !hasRole(*roles, allowed) {
+				return fmt.Errorf("%w: %q on field %q requires one of the roles %q", ErrOperatorNotAllowed, op, source, allowed)
 			}
 		}
 	}
-	return func(query url.Values) (QResult, error) {
-		result := NewQResult()
-		projections := make(map[string]int)
-		projsum := 1 // incremented or decremented with each +/- operator found on a qprj qvalue. normalized to 0 or 1 after summing the operators
-		sorts := make(map[string]int)
-		// map projections and sum
-		for _, proj := range strings.Split(query.Get(prj), ",") {
-			if len(proj) == 0 {
-				continue
+	if field.Validation != "" && p.tagValidator != nil {
+		values := []string{qvalue}
+		if !field.IsMeta {
+			values = []string{}
+			for _, v := range toOpValueMap(qvalue, field.Type, p.syntax) {
+				values = append(values, v...)
 			}
-			if strings.HasPrefix(proj, inc) {
-				projections[proj[1:]] = 1
-				projsum++
-			} else if strings.HasPrefix(proj, exc) {
-				projections[proj[1:]] = -1
-				projsum--
-			} else {
-				projections[proj] = 1
-				projsum++
+		}
+		for _, v := range values {
+			if err := p.tagValidator(v, field.Validation); err != nil {
+				return fmt.Errorf("%w: %q on field %q failed %q: %v", ErrValueNotValid, v, source, field.Validation, err)
 			}
 		}
-		// normalize projsum to 0 or 1
-		projsum = int(math.Max(0, math.Min(1, float64(projsum))))
+	}
+	return nil
+}
 
-		// map sorts
-		for _, sort := range strings.Split(query.Get(srt), ",") {
-			if len(sort) == 0 {
-				continue
+// processContext - Converts the provided URL query to a QResult without recovering from panics
+func (p *QProcessor) processContext(ctx context.Context, query url.Values) (QResult, error) {
+	var errs QErrors
+	// degrade - Records the error and returns true when the processor is best-effort, so the caller can skip the failing part of the query
+	degrade := func(err error) bool {
+		if !p.IsBestEffort {
+			return false
+		}
+		errs = append(errs, err)
+		return true
+	}
+	if p.IsStrict {
+		for _, field := range p.fields {
+			n := len(query[field.Key])
+			for _, a := range field.Aliases {
+				n += len(query[a])
 			}
-			if strings.HasPrefix(sort, asc) {
-				sorts[sort[1:]] = 1
-			} else if strings.HasPrefix(sort, des) {
-				sorts[sort[1:]] = -1
-			} else {
-				sorts[sort] = 1
+			if p.features.EnableBrackets {
+				// repeated bracketed keys are one list
+				for _, k := range append([]string{field.Key}, field.Aliases...) {
+					if len(query[k+"[]"]) > 0 {
+						n++
+					}
+				}
+			}
+			if field.isMergeSet {
+				// values of more than one of the key and aliases are handled by the merge policy, so only repeats of one key are counted
+				n = 0
+				for _, k := range append([]string{field.Key}, field.Aliases...) {
+					if len(query[k]) > n {
+						n = len(query[k])
+					}
+				}
 			}
+			if n > 1 {
+				err := fmt.Errorf("field %q appears %d times in query - use the key or one of its aliases only once", field.Key, n)
+				if degrade(err) {
+					continue
+				}
+				return QResult{}, err
+			}
+		}
+	}
+	result := NewQResult()
+	result.IsRedacted = p.redact
+	// pre-size the filter for the parameters that can become filter entries so large filters are not repeatedly grown
+	size := len(query)
+	if size > len(p.fields) {
+		size = len(p.fields)
+	}
+	result.Filter = make(bson.M, size)
+	projections := make(map[string]int, strings.Count(query.Get(prj), ",")+1)
+	projsum := 1 // incremented or decremented with each +/- operator found on a qprj qvalue. normalized to 0 or 1 after summing the operators
+	// map projections and sum
+	for _, proj := range strings.Split(query.Get(prj), ",") {
+		if len(proj) == 0 {
+			continue
+		}
+		if p.syntax >= SyntaxV2 && strings.HasPrefix(proj, spc) {
+			projections[strings.TrimLeft(proj, spc)] = 1
+			projsum++
+		} else if strings.HasPrefix(proj, inc) {
+			projections[proj[1:]] = 1
+			projsum++
+		} else if strings.HasPrefix(proj, exc) {
+			projections[proj[1:]] = -1
+			projsum--
+		} else {
+			projections[proj] = 1
+			projsum++
 		}
+	}
+	// normalize projsum to 0 or 1
+	projsum = int(math.Max(0, math.Min(1, float64(projsum))))
 
-		// apply limit
-		if l, err := strconv.ParseInt(query.Get(lmt), 10, 64); err == nil {
+	// apply limit - lmt=0 is treated the same as a missing lmt so it can never be used to request all documents
+	result.Limit = p.defaultLimit
+	if qlmt := query.Get(lmt); qlmt != "" {
+		if l, err := strconv.ParseInt(qlmt, 10, 64); err != nil {
+			result.warn(lmt, qlmt, fmt.Sprintf("limit ignored - %v", err))
+		} else if l <= 0 {
+			result.warn(lmt, qlmt, "limit ignored - must be greater than 0")
+		} else {
 			result.Limit = l
 		}
-		// apply skip
-		if s, err := strconv.ParseInt(query.Get(skp), 10, 64); err == nil {
+	}
+	// apply unlimited
+	if qunl := query.Get(unl); qunl != "" {
+		if u, err := strconv.ParseBool(qunl); err != nil {
+			result.warn(unl, qunl, fmt.Sprintf("unlimited ignored - %v", err))
+		} else if u && p.unlimitedCap == 0 {
+			result.warn(unl, qunl, "unlimited ignored - unlimited queries are not allowed")
+		} else if u {
+			result.Limit = p.unlimitedCap
+		}
+	}
+	// apply hard cap
+	if p.hardCap > 0 && (result.Limit == 0 || result.Limit > p.hardCap) {
+		result.Limit = p.hardCap
+	}
+	// apply skip
+	if qskp := query.Get(skp); qskp != "" {
+		if s, err := strconv.ParseInt(qskp, 10, 64); err != nil {
+			result.warn(skp, qskp, fmt.Sprintf("skip ignored - %v", err))
+		} else if s < 0 {
+			result.warn(skp, qskp, "skip ignored - must not be negative")
+		} else {
 			result.Skip = s
 		}
+	}
 
-		// process fields
-		for _, field := range fields {
-			// apply projections
-			if field.IsProjectable {
-				if _, ok := projections[field.Key]; ok {
-					result.Projection[field.Key] = projsum
-				} else {
-					for _, alias := range field.Aliases {
-						if _, ok := projections[alias]; ok {
-							result.Projection[field.Key] = projsum
-						}
-					}
+	// map suppressed defaults
+	nodefaults := make(map[string]bool)
+	if qndf := query.Get(ndf); qndf != "" {
+		if p.IsDefaultSuppressible {
+			for _, key := range strings.Split(qndf, ",") {
+				nodefaults[key] = true
+			}
+		} else {
+			result.warn(ndf, qndf, "default suppression ignored - suppressing defaults is not allowed")
+		}
+	}
+
+	// process fields
+	denied := []string{} // PII fields the caller has not been granted access to
+	used := []usedField{} // fields supplied by the query - only collected when tracking usage
+	aliases := []string{} // aliases used in srt and prj - only collected when tracking usage
+	var fold func(string) string // case folding of foldable fields requested with fld
+	now := time.Now() // relative datetimes of every field are offsets from the same time
+	switch qfld := query.Get(fld); qfld {
+	case "":
+	case "lower":
+		fold = strings.ToLower
+	case "upper":
+		fold = strings.ToUpper
+	default:
+		result.warn(fld, qfld, "case folding ignored - must be lower or upper")
+	}
+	var roles []string // roles of the caller - only resolved when an operator is restricted
+	var jobs []filterJob // filters to build concurrently - only collected when the processor is parallel
+	if p.parallelism > 1 {
+		jobs = make([]filterJob, 0, len(p.fields))
+	}
+	for _, field := range p.fields {
+		// abandon processing if the request has already been cancelled
+		if err := ctx.Err(); err != nil {
+			return QResult{}, err
+		}
+		if field.IsPII {
+			// PII values are masked by Redacted whether or not the caller has been granted access
+			if result.pii == nil {
+				result.pii = make(map[string]bool)
+			}
+			result.pii[field.dbKey()] = true
+			for _, key := range append([]string{field.Key}, field.Aliases...) {
+				result.pii[key] = true
+			}
+		}
+		// apply projections
+		if field.IsPII && (p.piiGrant == nil || !p.piiGrant(ctx, field.Key)) {
+			// PII fields are always excluded unless the caller has been granted access
+			denied = append(denied, field.dbKey())
+			for _, key := range append([]string{field.Key}, field.Aliases...) {
+				if ord, ok := projections[key]; ok && ord == 1 {
+					result.warn(prj, key, "projection ignored - access to the field has not been granted")
 				}
 			}
-			// apply sorts
-			if field.IsSortable {
-				if ord, ok := sorts[field.Key]; ok {
-					result.Sort[field.Key] = ord
-				} else {
-					for _, alias := range field.Aliases {
-						if ord, ok := sorts[alias]; ok {
-							result.Sort[field.Key] = ord
+		} else if field.IsProjectable {
+			if _, ok := projections[field.Key]; ok {
+				result.Projection[field.dbKey()] = projsum
+			} else {
+				for _, alias := range field.Aliases {
+					if _, ok := projections[alias]; ok {
+						result.Projection[field.dbKey()] = projsum
+						if p.usage != nil {
+							aliases = append(aliases, alias)
 						}
 					}
 				}
 			}
-			// apply values
-			qvalue := query.Get(field.Key)
-			// search for applicable alias if field is not found by key
-			if qvalue == "" {
-				for _, a := range field.Aliases {
-					qvalue = query.Get(a)
-					if qvalue != "" {
-						// alias found - break loop
-						break
-					}
+		}
+		if field.IsNotFilterable {
+			// skip filter logic as the field is only used in sorts and projections
+			continue
+		}
+		// apply values
+		qvalue, source := "", field.Key
+		sources, values := []string{}, []string{}
+		for _, k := range append([]string{field.Key}, field.Aliases...) {
+			if v := query.Get(k); v != "" {
+				sources = append(sources, k)
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			qvalue, source = values[0], sources[0]
+		}
+		if len(values) > 1 {
+			switch field.MergePolicy {
+			case MergeCombine:
+				qvalue = strings.Join(values, ",")
+			case MergeReject:
+				err := fmt.Errorf("%w: field %q has values for %q", ErrValueConflict, field.Key, sources)
+				if degrade(err) {
+					continue
+				}
+				return QResult{}, err
+			default:
+				for i := 1; i < len(values); i++ {
+					result.warn(sources[i], values[i], fmt.Sprintf("value ignored - %q was used", source))
 				}
 			}
-			if qvalue == "" && field.HasDefaultFunc {
-				qvalue = field.Default()
+		}
+		if qvalue == "" && p.features.EnableBrackets {
+			// bracketed keys are only used when the field is not found by key or alias
+			for _, k := range append([]string{field.Key}, field.Aliases...) {
+				qvalue = bracketValue(query, k)
+				if qvalue != "" {
+					source = k + "[]"
+					break
+				}
 			}
-			if qvalue == "" {
-				// skip to next field since no qvalue was found so it doesn't appear in the Filter at all
-				continue
+		}
+		if qvalue != "" {
+			qvalue = p.expandLists(qvalue)
+		}
+		if qvalue != "" && p.usage != nil {
+			used = append(used, usedField{key: field.Key, dbkey: field.dbKey(), source: source, qvalue: qvalue, t: field.Type, syntax: p.syntax})
+		}
+		if qvalue != "" {
+			// only values sent by the client are checked - defaults are controlled by the server
+			if err := p.checkValue(ctx, field, source, qvalue, &roles); err != nil {
+				if degrade(err) {
+					continue
+				}
+				return QResult{}, err
 			}
-			if field.IsMeta {
-				result.Meta[field.Key] = qvalue
-				// skip further logic as meta fields should not be used in projections, sorts, or filters
+		}
+		if field.IsFoldable {
+			field.fold = fold
+		}
+		field.features = p.features
+		field.now = now
+		active := isActive(field, query)
+		if !active {
+			// the interceptor only transforms values when the field is activated
+			field.Interceptor = nil
+		}
+		if qvalue == "" && field.HasDefaultFunc && active && !isSuppressed(field, nodefaults) {
+			qvalue = field.Default()
+			if qvalue != "" {
+				result.DefaultsApplied = append(result.DefaultsApplied, field.Key)
+			}
+		}
+		if qvalue == "" {
+			// skip to next field since no qvalue was found so it doesn't appear in the Filter at all
+			continue
+		}
+		if field.IsMeta {
+			result.Meta[field.Key] = qvalue
+			// skip further logic as meta fields should not be used in projections, sorts, or filters
+			continue
+		}
+		if p.parallelism > 1 {
+			// filters are built concurrently once every field's qvalue is known
+			jobs = append(jobs, filterJob{field: field, qvalue: qvalue})
+			continue
+		}
+		// apply filter
+		if err := field.applyFilter(qvalue, &result, p.syntax); err != nil {
+			if degrade(err) {
 				continue
 			}
-			// apply filter
-			field.ApplyFilter(qvalue, &result)
+			return QResult{}, err
+		}
+		// apply visibility conditions
+		if _, ok := result.Parsed[field.dbKey()]; ok && field.Visibility != nil {
+			if cond := field.Visibility(ctx); len(cond) > 0 {
+				result.and(cond)
+			}
+		}
+	}
+	if len(jobs) > 0 {
+		if err := p.applyFilters(ctx, jobs, &result, degrade); err != nil {
+			return QResult{}, err
+		}
+	}
+	if p.IsStrict {
+		for _, field := range p.fields {
+			if n := result.ValueCounts[field.Key].Invalid; field.IsAllOrNothing && n > 0 {
+				err := fmt.Errorf("%w: %d values of field %q could not be parsed", ErrValueNotValid, n, field.Key)
+				if degrade(err) {
+					continue
+				}
+				return QResult{}, err
+			}
+		}
+	}
+
+	// apply templates
+	for _, qtpl := range query[tpl] {
+		if err := p.applyTemplate(qtpl, &result); err != nil && !degrade(err) {
+			return QResult{}, err
+		}
+	}
+
+	// apply or and nor groups
+	for _, key := range []string{or, nor} {
+		for _, qgroup := range query[key] {
+			if err := p.applyGroup(ctx, key, qgroup, query, fold, now, &roles, &result); err != nil && !degrade(err) {
+				return QResult{}, err
+			}
+		}
+	}
+
+	// apply vector search - after all other filters so they can be composed into the stage
+	if qvec := query.Get(vec); qvec != "" {
+		if err := p.applyVectorSearch(ctx, qvec, &result); err != nil && !degrade(err) {
+			return QResult{}, err
+		}
+	}
+
+	// apply sorts in the order they appear in the query
+	sorted := make(map[string]bool)
+	virtuals := make(map[string]*QVirtual) // virtual fields resolved for this query - nil when not available
+	appendSort := func(key string, ord interface{}, entry string, fkey string) {
+		if sorted[key] {
+			// the first sort for a key takes precedence
+			return
+		}
+		sorted[key] = true
+		result.Sort = append(result.Sort, bson.E{Key: key, Value: ord})
+		result.SortInputs[key] = entry
+		result.SortFields[key] = fkey
+	}
+	// resolveSort - Returns the document path and canonical key of the sortable field or extra sort key with the provided key or alias
+	resolveSort := func(key string) (string, string, bool) {
+		for _, field := range p.fields {
+			if field.IsSortable && (field.Key == key || hasAlias(field, key)) {
+				if field.Key != key && p.usage != nil {
+					aliases = append(aliases, key)
+				}
+				// always sort by the canonical key, or its DBKey, even when an alias was used
+				return field.dbKey(), field.Key, true
+			}
+		}
+		for _, extra := range p.extraSortKeys {
+			if extra == key {
+				return key, key, true
+			}
+		}
+		return "", "", false
+	}
+	for _, entry := range strings.Split(query.Get(srt), ",") {
+		if len(entry) == 0 {
+			continue
+		}
+		key, ord := toSort(entry, p.syntax)
+		if preset, ok := p.sortPresets[key]; ok {
+			for _, pentry := range preset {
+				pkey, pord := toSort(pentry, p.syntax)
+				if path, fkey, ok := resolveSort(pkey); ok {
+					appendSort(path, pord*ord, entry, fkey)
+				} else {
+					appendSort(pkey, pord*ord, entry, pkey)
+				}
+			}
+			continue
+		}
+		if path, fkey, ok := resolveSort(key); ok {
+			appendSort(path, ord, entry, fkey)
+		} else if _, ok := p.virtual[key]; ok {
+			if v, ok := p.resolveVirtual(ctx, query, key, srt, entry, &result, virtuals); ok {
+				appendSort(key, v.value(ord), entry, key)
+			}
+		}
+	}
+	if len(result.Sort) == 0 {
+		for _, entry := range p.defaultSort {
+			key, ord := toSort(entry, p.syntax)
+			if path, fkey, ok := resolveSort(key); ok {
+				appendSort(path, ord, entry, fkey)
+			} else {
+				appendSort(key, ord, entry, key)
+			}
 		}
+	}
 
-		return result, nil
+	// apply extra projections
+	for _, extra := range p.extraProjectionKeys {
+		if _, ok := projections[extra]; ok {
+			result.Projection[extra] = projsum
+		}
 	}
-}
\ No newline at end of file
+
+	// apply computed projections - only inclusions are meaningful since excluding a computed field is the same as not computing it
+	if projsum == 1 {
+		for name, expr := range p.computed {
+			if _, ok := projections[name]; ok {
+				result.AddFields[name] = expr
+				result.Projection[name] = projsum
+			}
+		}
+	}
+
+	// apply virtual projections - like computed projections only inclusions are meaningful
+	if projsum == 1 {
+		for name := range p.virtual {
+			if _, ok := projections[name]; ok {
+				if v, ok := p.resolveVirtual(ctx, query, name, prj, query.Get(prj), &result, virtuals); ok {
+					result.Projection[name] = v.value(projsum)
+				}
+			}
+		}
+	}
+
+	// exclude denied PII fields - an inclusion projection already excludes them and cannot be mixed with exclusions
+	if projsum == 0 || len(result.Projection) == 0 {
+		for _, key := range denied {
+			result.Projection[key] = 0
+		}
+	}
+
+	// apply Atlas Search - after projections so the highlight projection cannot change which fields are excluded
+	if qsch := query.Get(sch); qsch != "" {
+		if err := p.applySearch(qsch, query.Get(hlt), &result); err != nil && !degrade(err) {
+			return QResult{}, err
+		}
+	}
+
+	// route old data queries to the archive
+	if p.archive != nil {
+		result.TargetsArchive = p.archive.targetsArchive(result.Parsed[p.fieldByKey(p.archive.key).dbKey()])
+	}
+
+	// check shard key targeting
+	scatter := len(p.shardKey) > 0 && p.checkShardKey(&result)
+
+	// check time series bucket usage
+	if p.timeseries != nil {
+		p.checkTimeSeries(&result)
+	}
+
+	// apply minimum score
+	if qmsc := query.Get(msc); qmsc != "" {
+		if m, err := strconv.ParseFloat(qmsc, 64); err != nil {
+			result.warn(msc, qmsc, fmt.Sprintf("minimum score ignored - %v", err))
+		} else if m <= 0 {
+			result.warn(msc, qmsc, "minimum score ignored - must be greater than 0")
+		} else if result.Search == nil && result.VectorSearch == nil {
+			result.warn(msc, qmsc, fmt.Sprintf("minimum score ignored - only used with %s or %s", sch, vec))
+		} else {
+			result.MinScore = m
+		}
+	}
+
+	// defense in depth - no merged input may add operators that run code on the server
+	if err := Sanitize(result.Filter); err != nil {
+		return QResult{}, err
+	}
+
+	if p.usage != nil {
+		p.usage.record(used, aliases, result.Sort, scatter)
+	}
+	if p.learner != nil {
+		p.learner.record(p, query)
+	}
+
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// NewQueryProcessor - Validates the provided QFields and returns a new QProcessor.
+func NewQueryProcessor(fields ...QField) *QProcessor {
+	validateFields(fields)
+	return &QProcessor{fields: fields, syntax: SyntaxLatest}
+}
+
+// validateFields - Ensures each field can be used by a processor. Exits if a field is invalid.
+func validateFields(fields []QField) {
+	// validate fields to ensures each field's Key and Aliases are not empty or using reserved values
+	for _, f := range fields {
+		switch {
+		case f.Key == "":
+			log.Fatal(fmt.Sprintf("Field %q cannot be an empty string\n", f.Key))
+		case isReserved(f.Key):
+			log.Fatal(fmt.Sprintf("Field %q is using a reserved key - reserved keys: %q\n", f.Key, reserved))
+		}
+		for _, a := range f.Aliases {
+			switch {
+			case a == "":
+				log.Fatal(fmt.Sprintf("Field %q alias cannot be an empty string\n", f.Key))
+			case isReserved(a):
+				log.Fatal(fmt.Sprintf("Field %q alias %q is using a reserved key - reserved keys: %q\n", f.Key, a, reserved))
+			}
+		}
+		if f.IsMeta {
+			if f.Type != QString {
+				// Although meta fields are not processed the same as other fields, and having the Type set to something other than QString will not break the processor,
+				// developers should be told they are attempting to do something that will not work as expected since meta fields will always be parsed as QString
+				log.Fatal(fmt.Sprintf("Field %q is a meta field and can only be parsed as type QString", f.Key))
+			}
+			if f.IsSortable || f.IsProjectable {
+				// Although meta fields are not processed the same as other fields, and having the IsProjectable and IsSortable flags set will not break the processor,
+				// developers should be told they are attempting to do something that will not work as expected since meta fields will never appear in the QResult Sort property
+				log.Fatal(fmt.Sprintf("Field %q is a meta field and will never appear in Projection or Sort - modify %q to not be projectable or sortable\n", f.Key, f.Key))
+			}
+		}
+		if f.Type == QCustom && f.Parser == nil {
+			log.Fatal(fmt.Sprintf("Field %q is a QCustom field and must have a parser - call UseParser\n", f.Key))
+		}
+		if f.IsNotFilterable {
+			if f.IsMeta {
+				log.Fatal(fmt.Sprintf("Field %q is a meta field and cannot be marked as not filterable\n", f.Key))
+			}
+			if !f.IsSortable && !f.IsProjectable {
+				// a field that is not filterable, sortable, or projectable would never appear in the QResult
+				log.Fatal(fmt.Sprintf("Field %q is not filterable and must be projectable or sortable\n", f.Key))
+			}
+		}
+	}
+}
+
+// Validate - Runs the Default function of each filterable field once and returns a QErrors with an error wrapping ErrValueNotValid for each default that does not produce a filter for the field's type, so misconfigured defaults can be reported at startup instead of silently producing empty filters. Defaults are parsed with the processor's syntax version and features, without the field's Interceptor, and an empty default is not an error since it applies no filter. Defaults with any value that cannot be parsed are reported, even when the field's Policy would drop only that clause. Call it when the Default functions return the values they will use in production, since time-dependent defaults are run when Validate is called.
+func (p *QProcessor) Validate() error {
+	errs := QErrors{}
+	for _, f := range p.fields {
+		if !f.HasDefaultFunc || f.IsMeta || f.IsNotFilterable {
+			continue
+		}
+		qvalue := f.Default()
+		if qvalue == "" {
+			continue
+		}
+		f.features = p.features
+		f.Interceptor = nil
+		clauses, counts, dropped, err := f.parse(p.expandLists(qvalue), p.syntax)
+		if err == nil && (dropped != nil || counts.Invalid > 0 || len(clauses) == 0) {
+			err = fmt.Errorf("%w: default %q of field %q has values that cannot be parsed as the field's type", ErrValueNotValid, qvalue, f.Key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// NewQProcessor - Validates the provided QFields and returns a function that converts a URL query to a QResult.
+func NewQProcessor(fields ...QField) QueryProcessorFn {
+	return NewQueryProcessor(fields...).Process
+}
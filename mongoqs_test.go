@@ -1,9 +1,19 @@
 package mongoqs
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rledford/mongoqs/grammar"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestNewQProcessor(t *testing.T) {
@@ -48,4 +58,2500 @@ func TestNewQProcessor(t *testing.T) {
 	if err == nil {
 		fmt.Println(result.String())
 	}
-}
\ No newline at end of file
+}
+func TestStrictRejectsDuplicateFields(t *testing.T) {
+	idField := NewQField("myObjectID")
+	idField.UseAliases("id").ParseAsObjectID()
+	qproc := NewQueryProcessor(idField).Strict()
+
+	qs := url.Values{}
+	qs.Add("myObjectID", "6050e7f529a90b22dc47f19e")
+	if _, err := qproc.Process(qs); err != nil {
+		t.Fatalf("expected no error for a single parameter, got %v", err)
+	}
+	qs.Add("id", "6050e7f529a90b22dc47f19f")
+	if _, err := qproc.Process(qs); err == nil {
+		t.Fatal("expected an error when the key and an alias are both used")
+	}
+}
+
+func TestComputedProjectionPipeline(t *testing.T) {
+	first := NewQField("first")
+	first.Projectable()
+	qproc := NewQueryProcessor(first).WithComputedProjection("fullName", bson.M{"$concat": bson.A{"$first", " ", "$last"}})
+
+	qs := url.Values{}
+	qs.Add("prj", "+first,+fullName")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.AddFields["fullName"]; !ok {
+		t.Fatalf("expected fullName in AddFields, got %v", result.AddFields)
+	}
+	pipeline := result.Pipeline()
+	if len(pipeline) != 2 {
+		t.Fatalf("expected $addFields and $project stages, got %v", pipeline)
+	}
+	if _, ok := pipeline[0]["$addFields"]; !ok {
+		t.Fatalf("expected first stage to be $addFields, got %v", pipeline[0])
+	}
+}
+
+func TestSortPresetsAndOrder(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().Sortable()
+	name := NewQField("name")
+	name.Sortable()
+	qproc := NewQueryProcessor(createdAt, name).WithSortPreset("relevance", "-score", "-createdAt")
+
+	qs := url.Values{}
+	qs.Add("srt", "name:asc,relevance:desc")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.D{{Key: "name", Value: 1}, {Key: "score", Value: 1}, {Key: "createdAt", Value: 1}}
+	if fmt.Sprint(result.Sort) != fmt.Sprint(want) {
+		t.Fatalf("expected sort %v, got %v", want, result.Sort)
+	}
+}
+
+func TestLeadingSpaceTreatedAsPlus(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable().Projectable()
+	qproc := NewQueryProcessor(myInt)
+
+	qs, _ := url.ParseQuery("srt=+myInt&prj=+myInt")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sort) != 1 || result.Sort[0].Key != "myInt" || result.Sort[0].Value != 1 {
+		t.Fatalf("expected ascending sort on myInt, got %v", result.Sort)
+	}
+	if result.Projection["myInt"] != 1 {
+		t.Fatalf("expected myInt to be included in projection, got %v", result.Projection)
+	}
+}
+
+func TestAliasSortUsesCanonicalKey(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseAliases("created", "date").Sortable()
+	qproc := NewQueryProcessor(createdAt)
+
+	for _, entry := range []string{"-created", "-date", "created:desc"} {
+		qs := url.Values{}
+		qs.Add("srt", entry)
+		result, err := qproc.Process(qs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := bson.D{{Key: "createdAt", Value: -1}}
+		if fmt.Sprint(result.Sort) != fmt.Sprint(want) {
+			t.Fatalf("srt=%s: expected sort %v, got %v", entry, want, result.Sort)
+		}
+		if result.SortInputs["createdAt"] != entry {
+			t.Fatalf("srt=%s: expected sort input %q, got %q", entry, entry, result.SortInputs["createdAt"])
+		}
+	}
+}
+
+func TestLimitSemantics(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("myString")).WithDefaultLimit(25)
+
+	for _, tc := range []struct {
+		query string
+		want  int64
+	}{
+		{"", 25},
+		{"lmt=0", 25},
+		{"lmt=-1", 25},
+		{"lmt=10", 10},
+		{"unl=true", 25},
+	} {
+		qs, _ := url.ParseQuery(tc.query)
+		result, _ := qproc.Process(qs)
+		if result.Limit != tc.want {
+			t.Fatalf("%q: expected limit %d, got %d", tc.query, tc.want, result.Limit)
+		}
+	}
+
+	qproc.AllowUnlimited(1000)
+	qs, _ := url.ParseQuery("lmt=10&unl=true")
+	if result, _ := qproc.Process(qs); result.Limit != 1000 {
+		t.Fatalf("expected unlimited query to use the cap, got %d", result.Limit)
+	}
+}
+
+func TestHardCap(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("myString")).AllowUnlimited(1000).WithHardCap(100)
+
+	for _, query := range []string{"", "lmt=500", "unl=true"} {
+		qs, _ := url.ParseQuery(query)
+		if result, _ := qproc.Process(qs); result.Limit != 100 {
+			t.Fatalf("%q: expected limit to be capped at 100, got %d", query, result.Limit)
+		}
+	}
+}
+
+func TestInvalidReservedValuesAreWarned(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("myString"))
+
+	qs, _ := url.ParseQuery("lmt=ten&skp=-5&unl=true")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 3 {
+		t.Fatalf("expected 3 warnings, got %v", result.Warnings)
+	}
+	if result.Warnings[0].Key != "lmt" || result.Warnings[0].Value != "ten" {
+		t.Fatalf("expected lmt warning with raw value, got %v", result.Warnings[0])
+	}
+}
+
+func TestDayRangeAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	// 2021-03-14 is 23 hours long in New York
+	start, end := DayRange(time.Date(2021, 3, 14, 12, 0, 0, 0, loc), loc)
+	if start.Hour() != 0 || end.Hour() != 0 || end.Sub(start) != 23*time.Hour {
+		t.Fatalf("expected a 23 hour day between local midnights, got %v - %v", start, end)
+	}
+}
+
+func TestUseDefaultClause(t *testing.T) {
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseDefaultClause(func() (string, interface{}) { return "gte", since })
+	status := NewQField("status")
+	status.UseDefaultClause(func() (string, interface{}) { return "in", []string{"active", "pending"} })
+
+	if got := createdAt.Default(); got != "gte:2021-01-01T00:00:00Z" {
+		t.Fatalf("unexpected datetime default %q", got)
+	}
+	if got := status.Default(); got != "in:active,pending" {
+		t.Fatalf("unexpected list default %q", got)
+	}
+}
+
+func TestDefaultSuppression(t *testing.T) {
+	status := NewQField("status")
+	status.UseAliases("st").UseDefault(func() string { return "active" })
+	qproc := NewQueryProcessor(status)
+
+	qs, _ := url.ParseQuery("ndf=st")
+	if result, _ := qproc.Process(qs); result.Filter["status"] == nil || len(result.Warnings) != 1 {
+		t.Fatalf("expected default to apply with a warning when suppression is not allowed, got %v", result.String())
+	}
+	if result, _ := qproc.Process(url.Values{}); len(result.DefaultsApplied) != 1 || result.DefaultsApplied[0] != "status" {
+		t.Fatalf("expected status to be recorded as a default, got %v", result.DefaultsApplied)
+	}
+	qproc.AllowDefaultSuppression()
+	for _, query := range []string{"ndf=st", "ndf=all"} {
+		qs, _ := url.ParseQuery(query)
+		if result, _ := qproc.Process(qs); result.Filter["status"] != nil {
+			t.Fatalf("%q: expected default to be suppressed, got %v", query, result.Filter)
+		}
+	}
+}
+
+func TestTrackUsage(t *testing.T) {
+	id := NewQField("myObjectID")
+	id.UseAliases("id").ParseAsObjectID()
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable().Projectable().UseAliases("n")
+	qproc := NewQueryProcessor(id, myInt).TrackUsage()
+
+	for _, query := range []string{"id=6050e7f529a90b22dc47f19e", "myInt=gt:1,lt:5&srt=-myInt", "myObjectID=in:6050e7f529a90b22dc47f19e", "srt=n&prj=n"} {
+		qs, _ := url.ParseQuery(query)
+		qproc.Process(qs)
+	}
+	usage := qproc.Usage()
+	if usage.Queries != 4 || usage.Fields["myObjectID"] != 2 || usage.Aliases["id"] != 1 || usage.Aliases["n"] != 1 {
+		t.Fatalf("unexpected field usage %+v", usage)
+	}
+	if usage.Operators["gt:"] != 1 || usage.Operators["eq:"] != 1 || usage.Sorts["myInt"] != 2 {
+		t.Fatalf("unexpected operator or sort usage %+v", usage)
+	}
+}
+
+func TestIndexAdvice(t *testing.T) {
+	status := NewQField("status")
+	name := NewQField("name")
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().Sortable()
+	qproc := NewQueryProcessor(status, name, createdAt).TrackUsage()
+
+	for _, query := range []string{
+		"status=active&createdAt=gte:2021-01-01T00:00:00Z&srt=-createdAt",
+		"status=active&createdAt=gte:2021-01-01T00:00:00Z&srt=-createdAt",
+		"name=like:smith",
+		"name=slike:smi",
+	} {
+		qs, _ := url.ParseQuery(query)
+		qproc.Process(qs)
+	}
+	report := qproc.IndexAdvice()
+	want := bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}
+	if len(report.Candidates) == 0 || fmt.Sprint(report.Candidates[0].Keys) != fmt.Sprint(want) || report.Candidates[0].Queries != 2 {
+		t.Fatalf("expected %v to be the top candidate, got %+v", want, report.Candidates)
+	}
+	if len(report.Findings) != 2 || report.Findings[0].Operator != like || report.Findings[1].Operator != slike {
+		t.Fatalf("expected findings for name like: and slike:, got %+v", report.Findings)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	v1 := NewQField("myInt")
+	v1.ParseAsInt()
+	v2 := NewQField("myInt")
+	v2.ParseAsFloat()
+
+	recorded := "# recorded queries\n?myInt=1\nmyInt=1.5\n/items?lmt=ten\nmyInt=%zz\n"
+	report, err := Replay(strings.NewReader(recorded), NewQueryProcessor(v1), NewQueryProcessor(v2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Queries != 4 || len(report.Entries) != 4 {
+		t.Fatalf("expected 4 queries and 4 entries, got %+v", report)
+	}
+	// int and float filters differ even when the values are equal since they are stored as different BSON types
+	for _, entry := range report.Entries[0:2] {
+		if len(entry.Diffs) != 1 || entry.Diffs[0].Section != "Filter" {
+			t.Fatalf("expected a filter diff, got %+v", entry)
+		}
+	}
+	if len(report.Entries[2].Warnings) != 1 {
+		t.Fatalf("expected a warning for lmt=ten, got %+v", report.Entries[2])
+	}
+	if report.Entries[3].ParseError == nil {
+		t.Fatalf("expected a parse error, got %+v", report.Entries[3])
+	}
+}
+
+func TestGolden(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable()
+	myString := NewQField("myString")
+	qproc := NewQueryProcessor(myInt, myString)
+
+	queries := []string{"myInt=gt:1,lt:10&myString=in:a,b&srt=-myInt", "lmt=ten"}
+	first, err := Golden(qproc, queries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/queries.golden"
+	if err := CompareGolden(path, first, true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		got, _ := Golden(qproc, queries...)
+		if err := CompareGolden(path, got, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	myInt.ParseAsFloat()
+	got, _ := Golden(NewQueryProcessor(myInt, myString), queries...)
+	if err := CompareGolden(path, got, false); err == nil {
+		t.Fatal("expected golden comparison to fail after changing a field type")
+	}
+}
+
+func TestWithSyntaxV1(t *testing.T) {
+	name := NewQField("name")
+	name.Sortable()
+	qs, _ := url.ParseQuery("srt=name:desc")
+
+	if result, _ := NewQueryProcessor(name).Process(qs); len(result.Sort) != 1 {
+		t.Fatalf("expected latest syntax to sort by name, got %v", result.Sort)
+	}
+	if result, _ := NewQueryProcessor(name).WithSyntax(SyntaxV1).Process(qs); len(result.Sort) != 0 {
+		t.Fatalf("expected v1 syntax to ignore the :desc suffix, got %v", result.Sort)
+	}
+}
+
+func TestNotFilterable(t *testing.T) {
+	score := NewQField("score")
+	score.ParseAsInt().Sortable().Projectable().NotFilterable()
+	qs, _ := url.ParseQuery("score=gt:5&srt=-score&prj=score")
+	result, err := NewQueryProcessor(score).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Filter) != 0 || len(result.Parsed) != 0 {
+		t.Fatalf("expected the value of a field that is not filterable to be ignored, got %v", result.Filter)
+	}
+	if fmt.Sprint(result.Sort) != "[{score -1}]" || result.Projection["score"] != 1 {
+		t.Fatalf("expected the field to still sort and project, got %v %v", result.Sort, result.Projection)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	name := NewQField("name")
+	email := NewQField("email")
+	admin := NewQueryProcessor(name, email).WithHardCap(1000)
+
+	publicEmail := NewQField("email")
+	publicEmail.NotFilterable().Sortable()
+	public := admin.Derive(publicEmail).WithHardCap(50)
+
+	qs, _ := url.ParseQuery("email=a@b.c&lmt=500")
+	result, _ := admin.Process(qs)
+	if result.Limit != 500 || result.Filter["email"] == nil {
+		t.Fatalf("expected admin processor to be unchanged, got %v", result.String())
+	}
+	result, _ = public.Process(qs)
+	if result.Limit != 50 || result.Filter["email"] != nil {
+		t.Fatalf("expected public processor to use overrides, got %v", result.String())
+	}
+}
+
+type roleKey struct{}
+
+func TestRestrictOperator(t *testing.T) {
+	name := NewQField("name")
+	qproc := NewQueryProcessor(name).
+		WithRoles(func(ctx context.Context) []string {
+			roles, _ := ctx.Value(roleKey{}).([]string)
+			return roles
+		}).
+		RestrictOperator("like", "admin")
+
+	qs, _ := url.ParseQuery("name=like:smith")
+	if _, err := qproc.Process(qs); !errors.Is(err, ErrOperatorNotAllowed) {
+		t.Fatalf("expected ErrOperatorNotAllowed, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), roleKey{}, []string{"admin"})
+	if _, err := qproc.ProcessContext(ctx, qs); err != nil {
+		t.Fatalf("expected admin to use like:, got %v", err)
+	}
+}
+
+func TestVisibilityFilter(t *testing.T) {
+	department := NewQField("department")
+	department.UseVisibilityFilter(func(ctx context.Context) bson.M {
+		return bson.M{"orgID": ctx.Value(roleKey{})}
+	})
+	qproc := NewQueryProcessor(department, NewQField("name"))
+	ctx := context.WithValue(context.Background(), roleKey{}, "org1")
+
+	qs, _ := url.ParseQuery("name=smith")
+	if result, _ := qproc.ProcessContext(ctx, qs); result.Filter["$and"] != nil {
+		t.Fatalf("expected no visibility conditions when department is not used, got %v", result.Filter)
+	}
+	qs, _ = url.ParseQuery("department=sales")
+	result, _ := qproc.ProcessContext(ctx, qs)
+	conds, _ := result.Filter["$and"].(bson.A)
+	if len(conds) != 1 || fmt.Sprint(conds[0]) != fmt.Sprint(bson.M{"orgID": "org1"}) {
+		t.Fatalf("expected org visibility condition, got %v", result.Filter)
+	}
+}
+
+func TestPIIProjection(t *testing.T) {
+	name := NewQField("name")
+	name.Projectable()
+	email := NewQField("email")
+	email.Projectable().PII()
+	qproc := NewQueryProcessor(name, email)
+
+	qs, _ := url.ParseQuery("prj=name,email")
+	result, _ := qproc.Process(qs)
+	if _, ok := result.Projection["email"]; ok || len(result.Warnings) != 1 {
+		t.Fatalf("expected email to be left out of the inclusion projection with a warning, got %v", result.String())
+	}
+	result, _ = qproc.Process(url.Values{})
+	if v, ok := result.Projection["email"]; !ok || v != 0 {
+		t.Fatalf("expected email to be excluded by default, got %v", result.Projection)
+	}
+	qproc.WithPIIGrant(func(ctx context.Context, key string) bool { return true })
+	result, _ = qproc.Process(qs)
+	if result.Projection["email"] != 1 {
+		t.Fatalf("expected granted email to be included, got %v", result.Projection)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name")).WithTemplate("activeSince", func(args ...interface{}) bson.M {
+		return bson.M{"status": "active", "lastSeen": bson.M{"$gte": args[0]}}
+	}, QDateTime)
+
+	qs, _ := url.ParseQuery("tpl=activeSince:2021-01-01T00:00:00Z")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conds, _ := result.Filter["$and"].(bson.A); len(conds) != 1 {
+		t.Fatalf("expected template conditions in $and, got %v", result.Filter)
+	}
+	for _, query := range []string{"tpl=activeSince:yesterday", "tpl=activeSince", "tpl=unknown:1"} {
+		qs, _ := url.ParseQuery(query)
+		if _, err := qproc.Process(qs); err == nil {
+			t.Fatalf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestDecodePath(t *testing.T) {
+	id := primitive.NewObjectID()
+	price, _ := primitive.ParseDecimal128("1.50")
+	doc := bson.M{
+		"_id":     id,
+		"created": primitive.NewDateTimeFromTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)),
+		"items":   bson.A{bson.M{"price": price}},
+	}
+	decodePath(doc, []string{"_id"}, DecodeObjectIDHex)
+	decodePath(doc, []string{"created"}, DecodeDateTimeRFC3339)
+	decodePath(doc, []string{"items", "price"}, DecodeDecimalString)
+
+	if doc["_id"] != id.Hex() || doc["created"] != "2021-01-01T00:00:00Z" {
+		t.Fatalf("unexpected decoded document %v", doc)
+	}
+	if price := doc["items"].(bson.A)[0].(bson.M)["price"]; price != "1.50" {
+		t.Fatalf("expected decimal price to be decoded, got %v", price)
+	}
+}
+
+// fakeCollection - QCollection that returns copies of its documents and records the QResults it receives
+type fakeCollection struct {
+	docs []bson.M
+	results []QResult
+}
+
+func (c *fakeCollection) Find(ctx context.Context, r QResult) ([]bson.M, error) {
+	c.results = append(c.results, r)
+	docs := []bson.M{}
+	for _, d := range c.docs {
+		doc := bson.M{}
+		for k, v := range d {
+			doc[k] = v
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (c *fakeCollection) Count(ctx context.Context, r QResult) (int64, error) {
+	c.results = append(c.results, r)
+	return int64(len(c.docs)), nil
+}
+
+func TestExecutorList(t *testing.T) {
+	id := NewQField("_id")
+	id.ParseAsObjectID().UseDecoder(DecodeObjectIDHex)
+	oid := primitive.NewObjectID()
+	coll := &fakeCollection{docs: []bson.M{{"_id": oid}}}
+	exec := NewQExecutor(NewQueryProcessor(id).WithDefaultLimit(10), coll)
+
+	qs, _ := url.ParseQuery("lmt=ten")
+	envelope, err := exec.List(context.Background(), qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope.Items) != 1 || envelope.Items[0]["_id"] != oid.Hex() || envelope.Limit != 10 || len(envelope.Warnings) != 1 {
+		t.Fatalf("unexpected envelope %+v", envelope)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	owner := NewQField("owner")
+	owner.UseInterceptor(func(op string, value interface{}) (interface{}, error) {
+		if op == "eq:" && value == "me" {
+			return "user-1", nil
+		}
+		if op == "ne:" {
+			return nil, nil
+		}
+		if op == "like:" {
+			return nil, errors.New("search is not supported")
+		}
+		return value, nil
+	})
+	qproc := NewQueryProcessor(owner)
+
+	qs, _ := url.ParseQuery("owner=me")
+	if result, _ := qproc.Process(qs); fmt.Sprint(result.Filter["owner"]) != fmt.Sprint(bson.M{"$eq": "user-1"}) {
+		t.Fatalf("expected eq:me to be rewritten, got %v", result.Filter)
+	}
+	qs, _ = url.ParseQuery("owner=ne:me")
+	if result, _ := qproc.Process(qs); result.Filter["owner"] != nil {
+		t.Fatalf("expected ne: clause to be dropped, got %v", result.Filter)
+	}
+	qs, _ = url.ParseQuery("owner=like:me")
+	if _, err := qproc.Process(qs); err == nil {
+		t.Fatal("expected like: to be rejected")
+	}
+}
+
+func TestListReferences(t *testing.T) {
+	status := NewQField("status")
+	qproc := NewQueryProcessor(status).WithList("terminalStatuses", func() []string { return []string{"closed", "cancelled"} })
+
+	qs, _ := url.ParseQuery("status=nin:@terminalStatuses,archived")
+	result, _ := qproc.Process(qs)
+	want := bson.M{"$nin": []string{"closed", "cancelled", "archived"}}
+	if fmt.Sprint(result.Filter["status"]) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, result.Filter["status"])
+	}
+	qs, _ = url.ParseQuery("status=@unknown")
+	if result, _ := qproc.Process(qs); fmt.Sprint(result.Filter["status"]) != fmt.Sprint(bson.M{"$eq": "@unknown"}) {
+		t.Fatalf("expected unknown list reference to be literal, got %v", result.Filter["status"])
+	}
+}
+
+func TestCoercionChain(t *testing.T) {
+	code := NewQField("code")
+	code.UseCoercion(QInt, QString)
+	qproc := NewQueryProcessor(code)
+
+	qs, _ := url.ParseQuery("code=in:1,A2,3")
+	result, _ := qproc.Process(qs)
+	want := bson.M{"$in": bson.A{int64(1), "A2", int64(3)}}
+	if fmt.Sprintf("%#v", result.Filter["code"]) != fmt.Sprintf("%#v", want) {
+		t.Fatalf("expected %#v, got %#v", want, result.Filter["code"])
+	}
+}
+
+func TestSortAndProjectionWithoutFilterValues(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable().Projectable()
+	myString := NewQField("myString")
+	qproc := NewQueryProcessor(myInt, myString)
+
+	qs, _ := url.ParseQuery("srt=-myInt&prj=myInt&myString=abc")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Filter["myInt"]; ok {
+		t.Fatalf("expected myInt not to be filtered, got %v", result.Filter)
+	}
+	if len(result.Sort) != 1 || result.Sort[0].Key != "myInt" || result.Sort[0].Value != -1 {
+		t.Fatalf("expected descending sort on myInt, got %v", result.Sort)
+	}
+	if result.Projection["myInt"] != 1 {
+		t.Fatalf("expected myInt to be projected, got %v", result.Projection)
+	}
+}
+
+func TestExtraSortAndProjectionKeys(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name")).WithExtraSortKeys("createdAt").WithExtraProjectionKeys("createdAt")
+
+	qs, _ := url.ParseQuery("srt=-createdAt,-updatedAt&prj=createdAt,updatedAt")
+	result, _ := qproc.Process(qs)
+	if len(result.Sort) != 1 || result.Sort[0].Key != "createdAt" {
+		t.Fatalf("expected only createdAt sort, got %v", result.Sort)
+	}
+	if len(result.Projection) != 1 || result.Projection["createdAt"] != 1 {
+		t.Fatalf("expected only createdAt projection, got %v", result.Projection)
+	}
+}
+
+func TestTypeScript(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable()
+	myString := NewQField("myString")
+	page := NewQField("page")
+	page.ParseAsMeta()
+	ts := NewQueryProcessor(myInt, myString, page).TypeScript("Item")
+
+	for _, want := range []string{
+		"export interface ItemFilter {",
+		"    gt?: number;",
+		"    like?: string;",
+		"    between?: [number, number];",
+		`    "not:in"?: (number)[];`,
+		`export type ItemSortKey = "myInt";`,
+		`export type ItemMetaKey = "page";`,
+		"export function buildItemQuery(query: ItemQuery): string {",
+	} {
+		if !strings.Contains(ts, want) {
+			t.Fatalf("expected TypeScript to contain %q:\n%s", want, ts)
+		}
+	}
+	if strings.Contains(ts, `"myInt"?: {`+"\n    eq?: number;\n    ne?: number;\n    gt?: number;\n    gte?: number;\n    lt?: number;\n    lte?: number;\n    like") {
+		t.Fatal("expected like: to only be generated for string fields")
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable().UseAliases("i")
+	myString := NewQField("myString")
+	out, err := NewQueryProcessor(myInt, myString).JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Pattern string `json:"pattern"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for key, cases := range map[string]map[string]bool{
+		"myInt": {"5": true, "gt:1,lt:10": true, "in:1,2,3": true, "like:5": false, "abc": false},
+		"i": {"gte:-2": true, "x": false},
+		"myString": {"like:abc": true, "anything at all": true},
+		srt: {"-myInt": true, "myInt:desc,i": true, "myString": false},
+	} {
+		prop, ok := schema.Properties[key]
+		if !ok {
+			t.Fatalf("expected property %q in schema", key)
+		}
+		re := regexp.MustCompile(prop.Pattern)
+		for value, want := range cases {
+			if got := re.MatchString(value); got != want {
+				t.Errorf("%s=%s matched %v, expected %v (pattern %s)", key, value, got, want, prop.Pattern)
+			}
+		}
+	}
+}
+
+func TestJSONSchemaAcceptsQueries(t *testing.T) {
+	count := NewQField("count")
+	count.ParseAsInt()
+	sku := NewQField("sku")
+	sku.AllowRegex(QRegexLimits{})
+	day := NewQField("day")
+	day.ParseAsDateTime().UseTimeLayout("2006-01-02", time.RFC1123)
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime()
+	qproc := NewQueryProcessor(count, sku, day, createdAt).
+		WithFeatures(QFeatures{EnableRelativeDates: true}).
+		WithVirtualField("score", func(ctx context.Context, query url.Values) (QVirtual, bool) {
+			return QVirtual{Meta: "textScore"}, true
+		})
+	out, err := qproc.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Pattern string `json:"pattern"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// every query the processor accepts without warnings must match the schema
+	for _, query := range []string{
+		"count=exists:true",
+		"count=between:1,5",
+		"count=emptyarray:",
+		"count=not:in:1,2",
+		"count=!eq:3",
+		"count=bitsallset:1,3",
+		"sku=re:^A[0-9]+",
+		"sku=not:like:abc",
+		"day=gte:2024-01-02",
+		"day=gte:Tue, 02 Jan 2024 00:00:00 UTC",
+		"createdAt=gte:now-24h",
+		"srt=-score",
+		"prj=score",
+	} {
+		qs, _ := url.ParseQuery(query)
+		result, err := qproc.Process(qs)
+		if err != nil || len(result.Warnings) != 0 {
+			t.Fatalf("%s: expected the query to be accepted, got %v %v", query, result.Warnings, err)
+		}
+		for key, values := range qs {
+			if re := regexp.MustCompile(schema.Properties[key].Pattern); !re.MatchString(values[0]) {
+				t.Errorf("%s: expected %q to match the schema pattern %s", query, values[0], re)
+			}
+		}
+	}
+	for key, value := range map[string]string{"count": "like:5", "createdAt": "gte:yesterday"} {
+		if regexp.MustCompile(schema.Properties[key].Pattern).MatchString(value) {
+			t.Errorf("expected %s=%s not to match the schema", key, value)
+		}
+	}
+}
+
+func TestHTTPFile(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable()
+	myString := NewQField("myString")
+	out := NewQueryProcessor(myInt, myString).HTTPFile("http://localhost:8080/items")
+
+	for _, want := range []string{
+		"### myInt gt\nGET http://localhost:8080/items?myInt=gt%3A1\n",
+		"### myInt in\nGET http://localhost:8080/items?myInt=in%3A1%2C2\n",
+		"### myInt descending\nGET http://localhost:8080/items?srt=-myInt\n",
+		"### myString like\n",
+		"### myInt between\nGET http://localhost:8080/items?myInt=between%3A1%2C2\n",
+		"### myInt !eq\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected .http file to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "### myInt like") {
+		t.Fatal("expected like: to only be generated for string fields")
+	}
+}
+
+// emailValidator - Tag validator standing in for go-playground/validator, which receives each raw value as a string
+func emailValidator(field interface{}, tag string) error {
+	if tag == "email" && !strings.Contains(field.(string), "@") {
+		return errors.New("not an email address")
+	}
+	return nil
+}
+
+func TestTagValidator(t *testing.T) {
+	email := NewQField("email")
+	email.UseValidation("email")
+	qproc := NewQueryProcessor(email).WithTagValidator(emailValidator)
+
+	if _, err := qproc.Process(url.Values{"email": {"in:a@example.com,b@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qproc.Process(url.Values{"email": {"in:a@example.com,bob"}}); !errors.Is(err, ErrValueNotValid) {
+		t.Fatalf("expected ErrValueNotValid but got %v", err)
+	}
+}
+
+func TestBestEffort(t *testing.T) {
+	email := NewQField("email")
+	email.UseValidation("email")
+	myString := NewQField("myString")
+	query := url.Values{"email": {"bob"}, "myString": {"abc"}, tpl: {"missing"}}
+
+	if _, err := NewQueryProcessor(email, myString).WithTagValidator(emailValidator).Process(query); !errors.Is(err, ErrValueNotValid) {
+		t.Fatalf("expected ErrValueNotValid but got %v", err)
+	}
+	for _, parallelism := range []int{1, 2} {
+		qproc := NewQueryProcessor(email, myString).WithTagValidator(emailValidator).WithParallelism(parallelism).BestEffort()
+		result, err := qproc.Process(query)
+		var errs QErrors
+		if !errors.As(err, &errs) || len(errs) != 2 || !errors.Is(errs[0], ErrValueNotValid) {
+			t.Fatalf("expected the validation and template errors but got %v", err)
+		}
+		if len(result.Filter) != 1 || result.Filter["myString"] == nil {
+			t.Fatalf("expected only myString to be filtered but got %v", result.Filter)
+		}
+	}
+}
+
+func TestProcessContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewQueryProcessor(NewQField("myString")).ProcessContext(ctx, url.Values{"myString": {"abc"}}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled but got %v", err)
+	}
+}
+
+func TestProcessRecoversPanics(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().Sortable().Projectable()
+	qproc := NewQueryProcessor(myInt).
+		WithSortPreset("newest", "-myInt").
+		WithTemplate("boom", func(args ...interface{}) bson.M { panic("boom") }, QInt)
+
+	// regression inputs from fuzzing must never produce an internal error
+	for _, q := range []url.Values{
+		{"myInt": {":"}, srt: {"-"}, prj: {"+"}},
+		{"myInt": {"in:,nin:,all:"}, srt: {":asc,:desc"}, prj: {"-,+, "}},
+		{"myInt": {"gt:gt:lt:"}, srt: {"--newest"}, lmt: {"-0"}, skp: {"9999999999999999999"}},
+		{"myInt": {"@"}, tpl: {""}},
+	} {
+		if _, err := qproc.Process(q); errors.Is(err, ErrInternal) {
+			t.Fatalf("expected %v to be processed without an internal error but got %v", q, err)
+		}
+	}
+
+	_, err := qproc.Process(url.Values{tpl: {"boom:1"}})
+	var perr *QPanicError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrInternal) || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Fatalf("expected a QPanicError wrapping ErrInternal but got %v", err)
+	}
+}
+
+// benchmarkFields - Returns n int fields and a query that filters on each of them
+func benchmarkFields(n int) ([]QField, url.Values) {
+	fields := make([]QField, n)
+	query := url.Values{}
+	for i := range fields {
+		key := fmt.Sprintf("field%d", i)
+		fields[i] = NewQField(key)
+		fields[i].ParseAsInt().Projectable()
+		query.Set(key, "gt:1,lt:100")
+	}
+	return fields, query
+}
+
+func BenchmarkProcess(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		fields, query := benchmarkFields(n)
+		qproc := NewQueryProcessor(fields...)
+		b.Run(fmt.Sprintf("fields=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				qproc.Process(query)
+			}
+		})
+	}
+}
+
+func TestWithParallelism(t *testing.T) {
+	fields, query := benchmarkFields(50)
+	fields[0].UseVisibilityFilter(func(ctx context.Context) bson.M { return bson.M{"org": 1} })
+	want, err := NewQueryProcessor(fields...).Process(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewQueryProcessor(fields...).WithParallelism(4).Process(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("expected parallel result\n%s\nto match sequential result\n%s", got.String(), want.String())
+	}
+
+	fields[1].UseInterceptor(func(op string, value interface{}) (interface{}, error) { panic("boom") })
+	if _, err := NewQueryProcessor(fields...).WithParallelism(4).Process(query); !errors.Is(err, ErrInternal) {
+		t.Fatalf("expected ErrInternal from a panicking interceptor but got %v", err)
+	}
+}
+
+func BenchmarkProcessParallel(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		fields, query := benchmarkFields(n)
+		qproc := NewQueryProcessor(fields...).WithParallelism(4)
+		b.Run(fmt.Sprintf("fields=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				qproc.Process(query)
+			}
+		})
+	}
+}
+
+func TestOperatorBoundaries(t *testing.T) {
+	// every pair of operators must be detected without one being mistaken for part of the other
+	for _, a := range oplist {
+		for _, b := range oplist {
+			got := toOpValueMap(a+"1,"+b+"2", QString, SyntaxLatest)
+			want := map[string][]string{a: {"1"}, b: {"2"}}
+			if a == b {
+				want = map[string][]string{a: {"1", "2"}}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s1,%s2 expected %v but got %v", a, b, want, got)
+			}
+		}
+	}
+	// operators are only detected at the beginning of the qvalue or after a ,
+	for qvalue, want := range map[string]map[string][]string{
+		"domain:example": {eq: {"domain:example"}},
+		"eq:domain:example": {eq: {"domain:example"}},
+		"xnin:1,gte:2": {eq: {"xnin:1"}, gte: {"2"}},
+		"nin:a,b,in:c": {nin: {"a", "b"}, in: {"c"}},
+		"slike:elike:": {slike: {"elike:"}},
+	} {
+		if got := toOpValueMap(qvalue, QString, SyntaxLatest); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s expected %v but got %v", qvalue, want, got)
+		}
+	}
+	// syntax versions before SyntaxV10 detect operators anywhere in the qvalue
+	for qvalue, want := range map[string]map[string][]string{
+		"domain:example": {eq: {"doma"}, in: {"example"}},
+		"xnin:1,gte:2": {eq: {"x"}, nin: {"1"}, gte: {"2"}},
+		"slike:elike:": {slike: {""}, elike: {""}},
+	} {
+		if got := toOpValueMap(qvalue, QString, SyntaxV9); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s expected %v but got %v with SyntaxV9", qvalue, want, got)
+		}
+	}
+}
+
+func TestGrammarOperators(t *testing.T) {
+	if fmt.Sprint(grammar.Operators) != fmt.Sprint(syntaxops[SyntaxLatest]) {
+		t.Fatalf("expected grammar operators %v to match the latest syntax operators %v", grammar.Operators, syntaxops[SyntaxLatest])
+	}
+}
+
+// sqlBackend - A QBackend that builds SQL WHERE fragments for the scalar comparison operators
+type sqlBackend struct{}
+
+func (sqlBackend) Condition(key string, clauses []QClause) (interface{}, error) {
+	ops := map[string]string{eq: "=", ne: "<>", gt: ">", gte: ">=", lt: "<", lte: "<="}
+	parts := []string{}
+	for _, c := range clauses {
+		op, ok := ops[c.Op]
+		if !ok {
+			return nil, fmt.Errorf("operator %q not supported", c.Op)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %v", key, op, c.Value))
+	}
+	return strings.Join(parts, " AND "), nil
+}
+
+func (sqlBackend) And(conditions []interface{}) (interface{}, error) {
+	parts := []string{}
+	for _, c := range conditions {
+		parts = append(parts, c.(string))
+	}
+	return strings.Join(parts, " AND "), nil
+}
+
+func TestBackend(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt()
+	myString := NewQField("myString")
+	myString.UseDefault(func() string { return "like:abc" })
+	qproc := NewQueryProcessor(myInt, myString)
+
+	qs, _ := url.ParseQuery("myInt=gte:1,lt:10")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := result.Build(MongoBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(filter) != fmt.Sprint(result.Filter) {
+		t.Fatalf("expected MongoBackend to build %v but got %v", result.Filter, filter)
+	}
+	if _, err := result.Build(sqlBackend{}); err == nil {
+		t.Fatal("expected the sql backend to reject like:")
+	}
+
+	qs, _ = url.ParseQuery("myInt=gte:1,lt:10&myString=abc")
+	result, _ = qproc.Process(qs)
+	where, err := result.Build(sqlBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if where != "myInt >= 1 AND myInt < 10 AND myString = abc" {
+		t.Fatalf("unexpected WHERE fragment %q", where)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt()
+	name := NewQField("name")
+	tags := NewQField("tags")
+	created := NewQField("created")
+	created.ParseAsDateTime()
+	qproc := NewQueryProcessor(myInt, name, tags, created)
+
+	docs := []bson.M{
+		{"myInt": int32(5), "name": "John Smith", "tags": bson.A{"a", "b"}, "created": primitive.NewDateTimeFromTime(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC))},
+		{"myInt": 50.0, "name": "Jane Doe", "tags": bson.A{"b"}},
+		{"name": "smithers"},
+	}
+	for query, want := range map[string][]bool{
+		"myInt=gt:1,lt:10": {true, false, false},
+		"myInt=nin:5": {false, true, true},
+		"myInt=ne:50": {true, false, true},
+		"name=like:SMITH": {true, false, true},
+		"name=slike:smith": {false, false, true},
+		"tags=b": {true, true, false},
+		"tags=all:a,b": {true, false, false},
+		"created=gte:2021-01-01T00:00:00Z": {true, false, false},
+	} {
+		qs, _ := url.ParseQuery(query)
+		result, err := qproc.Process(qs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := result.Match()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, doc := range docs {
+			if got := match(doc); got != want[i] {
+				t.Errorf("%s: expected document %d match to be %v", query, i, want[i])
+			}
+		}
+	}
+
+	if _, err := MatchFilter(bson.M{"loc": bson.M{"$near": 1}}); err == nil {
+		t.Fatal("expected an error for an unsupported operator")
+	}
+}
+
+func TestVerifyResult(t *testing.T) {
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt()
+	fixtures := []bson.M{{"_id": 1, "myInt": 1}, {"_id": 2, "myInt": 5}, {"_id": 3, "myInt": 10}}
+	qs, _ := url.ParseQuery("myInt=gt:2&lmt=1")
+	result, _ := NewQueryProcessor(myInt).Process(qs)
+
+	// the fake collection returns the documents the filter matches
+	if err := VerifyResult(context.Background(), &fakeCollection{docs: fixtures[1:]}, result, fixtures); err != nil {
+		t.Fatal(err)
+	}
+	// the fake collection returns all of its documents, like a server that ignored the filter
+	coll := &fakeCollection{docs: fixtures}
+	if err := VerifyResult(context.Background(), coll, result, fixtures); err == nil {
+		t.Fatal("expected an error when the collection finds documents that do not match")
+	}
+	if coll.results[0].Limit != 0 {
+		t.Fatal("expected the result to be verified without paging")
+	}
+
+	err := VerifyQueries(context.Background(), &fakeCollection{docs: fixtures[1:]}, NewQueryProcessor(myInt), fixtures, "myInt=gt:2", "myInt=gt:5", "myInt=%zz")
+	var errs QErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected the mismatched and unparsable queries to fail, got %v", err)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	id := NewQField("id")
+	id.ParseAsObjectID().UseFailurePolicy(PolicyFailRequest)
+	count := NewQField("count")
+	count.ParseAsInt().UseFailurePolicy(PolicyDropField)
+	size := NewQField("size")
+	size.ParseAsInt()
+	qproc := NewQueryProcessor(id, count, size)
+
+	qs, _ := url.ParseQuery("count=gt:1,lt:ten&size=gt:1,lt:ten")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Filter["count"]; ok || len(result.Warnings) != 1 || result.Warnings[0].Key != "count" {
+		t.Fatalf("expected count to be dropped with a warning, got %v %v", result.Filter, result.Warnings)
+	}
+	if fmt.Sprint(result.Filter["size"]) != "map[$gt:1]" {
+		t.Fatalf("expected only the invalid size clause to be dropped, got %v", result.Filter["size"])
+	}
+
+	qs, _ = url.ParseQuery("id=in:6050e7f529a90b22dc47f19e,nope")
+	if _, err := qproc.Process(qs); !errors.Is(err, ErrValueNotValid) {
+		t.Fatalf("expected ErrValueNotValid but got %v", err)
+	}
+}
+
+func TestDBKey(t *testing.T) {
+	created := NewQField("createdAt")
+	created.ParseAsInt().Sortable().Projectable().UseAliases("created").UseDBKey("meta.created")
+	qproc := NewQueryProcessor(created).WithSortPreset("newest", "-createdAt")
+
+	qs, _ := url.ParseQuery("created=gt:1&srt=-created&prj=created")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Filter["meta.created"]; !ok {
+		t.Fatalf("expected the filter to use the DBKey, got %v", result.Filter)
+	}
+	if _, ok := result.Projection["meta.created"]; !ok {
+		t.Fatalf("expected the projection to use the DBKey, got %v", result.Projection)
+	}
+	if len(result.Sort) != 1 || result.Sort[0].Key != "meta.created" || result.SortInputs["meta.created"] != "-created" || result.SortFields["meta.created"] != "createdAt" {
+		t.Fatalf("expected the sort to resolve alias -> key -> DBKey, got %v %v %v", result.Sort, result.SortInputs, result.SortFields)
+	}
+
+	qs, _ = url.ParseQuery("srt=newest")
+	result, _ = qproc.Process(qs)
+	if len(result.Sort) != 1 || result.Sort[0].Key != "meta.created" || result.Sort[0].Value != -1 {
+		t.Fatalf("expected the sort preset to resolve to the DBKey, got %v", result.Sort)
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	flags := NewQField("flags")
+	flags.ParseAsInt()
+	name := NewQField("name")
+	qproc := NewQueryProcessor(flags, name)
+
+	qs, _ := url.ParseQuery("flags=bitsallset:5,bitsallclear:1,3&name=bitsanyset:1")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bitwise operators only apply to int fields
+	if fmt.Sprint(result.Filter) != "map[flags:map[$bitsAllClear:[1 3] $bitsAllSet:5]]" {
+		t.Fatalf("unexpected filter %v", result.Filter)
+	}
+	match, err := result.Match()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match(bson.M{"flags": int32(5)}) || match(bson.M{"flags": int32(13)}) {
+		t.Fatal("unexpected bitwise match")
+	}
+
+	// pinned processors treat newer operators as values
+	result, _ = NewQueryProcessor(name).WithSyntax(SyntaxV2).Process(url.Values{"name": {"bitsallset:5"}})
+	if fmt.Sprint(result.Filter) != "map[name:map[$eq:bitsallset:5]]" {
+		t.Fatalf("expected bitsallset: to be a value for SyntaxV2, got %v", result.Filter)
+	}
+}
+
+func TestIPField(t *testing.T) {
+	addr := NewQField("addr")
+	addr.ParseAsIP()
+	num := NewQField("num")
+	num.ParseAsIP().UseIPStorage(IPNumeric)
+	qproc := NewQueryProcessor(addr, num)
+
+	qs, _ := url.ParseQuery("addr=10.0.0.0/8,192.168.0.1&num=10.0.0.0/8")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter["addr"]) != `map[$in:[{"pattern": "^10\.", "options": ""} 192.168.0.1]]` {
+		t.Fatalf("unexpected string filter %v", result.Filter["addr"])
+	}
+	if fmt.Sprint(result.Filter["num"]) != "map[$gte:167772160 $lte:184549375]" {
+		t.Fatalf("unexpected numeric filter %v", result.Filter["num"])
+	}
+
+	qs, _ = url.ParseQuery("addr=nin:172.16.0.0/12")
+	result, _ = qproc.Process(qs)
+	match, err := result.Match()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"172.16.0.1": false, "172.31.255.255": false, "172.32.0.1": true, "10.0.0.1": true} {
+		if got := match(bson.M{"addr": ip}); got != want {
+			t.Errorf("expected %s match to be %v", ip, want)
+		}
+	}
+
+	qs, _ = url.ParseQuery("addr=gt:10.0.0.1&num=gt:10.0.0.1")
+	result, _ = qproc.Process(qs)
+	if _, ok := result.Filter["addr"]; ok || fmt.Sprint(result.Filter["num"]) != "map[$gt:167772161]" {
+		t.Fatalf("expected gt: to only apply to numeric storage, got %v", result.Filter)
+	}
+}
+
+func TestSemverField(t *testing.T) {
+	v, err := ParseVersion("v1.2.3-beta.1+build")
+	if err != nil || v.String() != "1.2.3-beta.1" {
+		t.Fatalf("unexpected version %v %v", v, err)
+	}
+	if !(QVersion{Major: 1, Minor: 10}.Key() > QVersion{Major: 1, Minor: 9}.Key()) || !(QVersion{Major: 1}.Key() > QVersion{Major: 1, Prerelease: "rc.1"}.Key()) {
+		t.Fatal("expected keys to sort in version precedence order")
+	}
+
+	key := NewQField("key")
+	key.ParseAsSemver()
+	fields := NewQField("fields")
+	fields.ParseAsSemver().UseSemverStorage(SemverFields)
+	qs, _ := url.ParseQuery("key=gte:1.2&fields=gte:1.2.3,lt:2")
+	result, err := NewQueryProcessor(key, fields).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter["key"]) != "map[$gte:0000000001.0000000002.0000000000~]" {
+		t.Fatalf("unexpected key filter %v", result.Filter["key"])
+	}
+	match, err := result.Match()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := func(major, minor, patch int) bson.M {
+		return bson.M{"key": QVersion{Major: 1, Minor: 2}.Key(), "fields": bson.M{"major": major, "minor": minor, "patch": patch}}
+	}
+	for _, c := range []struct {
+		doc bson.M
+		want bool
+	}{{doc(1, 2, 3), true}, {doc(1, 10, 0), true}, {doc(1, 2, 2), false}, {doc(2, 0, 0), false}, {doc(0, 9, 9), false}} {
+		if got := match(c.doc); got != c.want {
+			t.Errorf("expected %v match to be %v", c.doc["fields"], c.want)
+		}
+	}
+}
+
+func TestVectorSearch(t *testing.T) {
+	category := NewQField("category")
+	resolve := func(ctx context.Context, ref string) ([]float64, error) {
+		if ref == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []float64{0.1, 0.2}, nil
+	}
+	qproc := NewQueryProcessor(category).WithVectorSearch("embeddings", "embedding", resolve)
+
+	qs, _ := url.ParseQuery("vec=doc1&category=books&lmt=5&skp=5")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := result.Pipeline()
+	stage, ok := pipeline[0]["$vectorSearch"].(bson.M)
+	if !ok || stage["limit"] != int64(10) || stage["numCandidates"] != int64(100) || fmt.Sprint(stage["filter"]) != "map[category:map[$eq:books]]" {
+		t.Fatalf("unexpected pipeline %v", pipeline)
+	}
+	if _, ok := pipeline[1]["$skip"]; !ok {
+		t.Fatalf("expected $skip to follow $vectorSearch, got %v", pipeline)
+	}
+	result.Attach("tenant", bson.M{"tenant": "a"})
+	stage = result.Pipeline()[0]["$vectorSearch"].(bson.M)
+	if fmt.Sprint(stage["filter"]) != "map[$and:[map[tenant:a]] category:map[$eq:books]]" {
+		t.Fatalf("expected the attached fragment in the vector search filter, got %v", stage["filter"])
+	}
+
+	qs, _ = url.ParseQuery("vec=missing")
+	if _, err := qproc.Process(qs); err == nil {
+		t.Fatal("expected an error when the reference cannot be resolved")
+	}
+	result, _ = NewQueryProcessor(category).Process(url.Values{vec: {"doc1"}})
+	if result.VectorSearch != nil || len(result.Warnings) != 1 {
+		t.Fatal("expected vec to be ignored with a warning when vector search is not enabled")
+	}
+}
+
+func TestAtlasSearchHighlights(t *testing.T) {
+	title := NewQField("title")
+	title.Projectable()
+	secret := NewQField("secret")
+	secret.PII()
+	qproc := NewQueryProcessor(title, secret).WithAtlasSearch("default", "title", "body")
+
+	qs, _ := url.ParseQuery("sch=mongodb&hlt=true&prj=title")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := result.Pipeline()
+	if _, ok := pipeline[0]["$search"]; !ok || result.Search["highlight"] == nil {
+		t.Fatalf("expected $search with highlights as the first stage, got %v", pipeline)
+	}
+	if fmt.Sprint(result.Projection[highlights]) != "map[$meta:searchHighlights]" {
+		t.Fatalf("expected the highlight projection, got %v", result.Projection)
+	}
+
+	// exclusion projections cannot include computed values so highlights are added as fields
+	qs, _ = url.ParseQuery("sch=mongodb&hlt=true")
+	result, _ = qproc.Process(qs)
+	if result.Projection["secret"] != 0 || result.AddFields[highlights] == nil {
+		t.Fatalf("expected highlights in AddFields with PII excluded, got %v %v", result.Projection, result.AddFields)
+	}
+
+	// without a projection, highlights in $project would drop every other field
+	result, _ = NewQueryProcessor(title).WithAtlasSearch("default", "title").Process(qs)
+	if len(result.Projection) != 0 || result.AddFields[highlights] == nil {
+		t.Fatalf("expected highlights in AddFields without a projection, got %v %v", result.Projection, result.AddFields)
+	}
+}
+
+func TestMinScore(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("title")).WithAtlasSearch("default", "title")
+
+	qs, _ := url.ParseQuery("sch=mongodb&msc=1.5&lmt=10")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := result.Pipeline()
+	if result.MinScore != 1.5 || fmt.Sprint(pipeline[1]) != "map[$match:map[$expr:map[$gte:[map[$meta:searchScore] 1.5]]]]" {
+		t.Fatalf("expected a score $match after $search, got %v", pipeline)
+	}
+
+	for _, q := range []string{"msc=1.5", "sch=mongodb&msc=-1", "sch=mongodb&msc=high"} {
+		qs, _ := url.ParseQuery(q)
+		result, _ := qproc.Process(qs)
+		if result.MinScore != 0 || len(result.Warnings) != 1 {
+			t.Fatalf("%s: expected the minimum score to be ignored with a warning, got %v %v", q, result.MinScore, result.Warnings)
+		}
+	}
+}
+
+func TestGridFSFields(t *testing.T) {
+	owner := NewQField("owner")
+	qproc := NewQueryProcessor(GridFSFields(owner)...)
+
+	qs, _ := url.ParseQuery("filename=sl:report&length=gte:1024&owner=alice&srt=-uploadDate&prj=filename")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"filename", "length", "metadata.owner"} {
+		if _, ok := result.Filter[key]; !ok {
+			t.Fatalf("expected a filter on %s, got %v", key, result.Filter)
+		}
+	}
+	if len(result.Sort) != 1 || result.Sort[0].Key != "uploadDate" || result.Projection["filename"] != 1 {
+		t.Fatalf("expected the uploadDate sort and filename projection, got %v %v", result.Sort, result.Projection)
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	ts := NewQField("ts")
+	ts.ParseAsDateTime().Sortable()
+	sensor := NewQField("sensor")
+	sensor.Sortable()
+	value := NewQField("value")
+	value.ParseAsFloat().Sortable()
+	qproc := NewQueryProcessor(ts, sensor, value).WithTimeSeries("ts", "sensor")
+
+	qs, _ := url.ParseQuery("ts=gte:2024-01-01T00:00:00Z&sensor=in:a,b&srt=sensor,ts")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", result.Warnings)
+	}
+
+	qs, _ = url.ParseQuery("sensor=like:a&srt=-value")
+	result, _ = qproc.Process(qs)
+	if len(result.Warnings) != 3 {
+		t.Fatalf("expected time range, meta operator, and sort warnings, got %v", result.Warnings)
+	}
+
+	// derived processors check the paths of their own overrides
+	ts.UseDBKey("meta.ts")
+	sensor.UseDBKey("meta.sensor")
+	qs, _ = url.ParseQuery("ts=gte:2024-01-01T00:00:00Z&sensor=in:a,b&srt=sensor,ts")
+	result, _ = qproc.Derive(ts, sensor).Process(qs)
+	if len(result.Warnings) != 0 {
+		t.Fatalf("expected no warnings from the derived processor, got %v", result.Warnings)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	count := NewQField("count")
+	count.ParseAsInt()
+	tags := NewQField("tags")
+	price := NewQField("price")
+	price.ParseAsFloat()
+	qproc := NewQueryProcessor(count, tags, price)
+
+	coll := &fakeCollection{docs: []bson.M{
+		{"count": int32(1), "tags": bson.A{"a", "b"}, "price": int64(3)},
+		{"count": "2", "tags": bson.A{"c", int32(4)}, "price": 1.5},
+		{"count": nil},
+	}}
+	drifts, err := qproc.DetectDrift(context.Background(), coll, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 2 || drifts[0].Key != "count" || drifts[0].Observed["string"] != 1 || drifts[0].Checked != 2 || drifts[1].Key != "tags" || drifts[1].Observed["int"] != 1 {
+		t.Fatalf("expected string counts and an int tag to be reported, got %+v", drifts)
+	}
+	if coll.results[0].Limit != 100 {
+		t.Fatalf("expected the sample size to be used as the limit, got %d", coll.results[0].Limit)
+	}
+}
+
+func TestLint(t *testing.T) {
+	name := NewQField("name")
+	status := NewQField("status")
+	status.UseCardinality(CardinalityLow)
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseCardinality(CardinalityHigh)
+	qproc := NewQueryProcessor(name, status, createdAt)
+
+	qs, _ := url.ParseQuery("name=like:smith&status=nin:closed&createdAt=gte:2024-01-01T00:00:00Z")
+	findings, err := qproc.Lint(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 3 || findings[0].Operator != like || findings[1].Operator != nin || findings[2].Operator != gte {
+		t.Fatalf("expected like, nin, and unbounded range findings, got %+v", findings)
+	}
+
+	qs, _ = url.ParseQuery("name=slike:smith")
+	if findings, _ := qproc.Lint(qs); len(findings) != 1 || findings[0].Operator != slike {
+		t.Fatalf("expected a slike finding, got %+v", findings)
+	}
+
+	qs, _ = url.ParseQuery("name=smith&status=closed&createdAt=gte:2024-01-01T00:00:00Z,lt:2024-02-01T00:00:00Z")
+	if findings, _ := qproc.Lint(qs); len(findings) != 0 {
+		t.Fatalf("expected no findings, got %+v", findings)
+	}
+}
+
+func TestProcessFederated(t *testing.T) {
+	title := NewQField("title")
+	title.Sortable()
+	author := NewQField("author")
+	duration := NewQField("duration")
+	duration.ParseAsInt()
+	qproc := NewQueryProcessor(title, author, duration).
+		WithCollection("books", "title", "author").
+		WithCollection("podcasts", "title", "duration").
+		WithCollection("films", "title", "duration").
+		TrackUsage()
+
+	qs, _ := url.ParseQuery("title=like:go&duration=lt:60&srt=title")
+	federated, err := qproc.ProcessFederated(context.Background(), qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(federated.Collections) != "[podcasts films]" || fmt.Sprint(federated.Skipped) != "map[books:[duration]]" {
+		t.Fatalf("expected books to be skipped, got %v %v", federated.Collections, federated.Skipped)
+	}
+	podcasts := federated.Results["podcasts"]
+	if len(podcasts.Filter) != 2 || len(podcasts.Sort) != 1 {
+		t.Fatalf("expected the title and duration filters and the title sort, got %v %v", podcasts.Filter, podcasts.Sort)
+	}
+	if usage := qproc.Usage(); usage.Queries != 1 || usage.Fields["title"] != 1 {
+		t.Fatalf("expected the request to be counted once, got %+v", usage)
+	}
+}
+
+func TestFederatedPipeline(t *testing.T) {
+	status := NewQField("status")
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().Sortable()
+	qproc := NewQueryProcessor(status, createdAt).
+		WithCollection("orders", "status", "createdAt").
+		WithCollection("orders_archive", "status", "createdAt")
+
+	qs, _ := url.ParseQuery("status=shipped&srt=-createdAt&lmt=10&skp=20")
+	federated, err := qproc.ProcessFederated(context.Background(), qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, pipeline := federated.Pipeline()
+	expected := "[map[$match:map[status:map[$eq:shipped]]] map[$sort:[{createdAt -1}]] map[$limit:30] " +
+		"map[$unionWith:map[coll:orders_archive pipeline:[map[$match:map[status:map[$eq:shipped]]] map[$sort:[{createdAt -1}]] map[$limit:30]]]] " +
+		"map[$sort:[{createdAt -1}]] map[$skip:20] map[$limit:10]]"
+	if coll != "orders" || fmt.Sprint(pipeline) != expected {
+		t.Fatalf("expected a $unionWith pipeline on orders, got %s %v", coll, pipeline)
+	}
+}
+
+func TestArchiveRouting(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime()
+	status := NewQField("status")
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	qproc := NewQueryProcessor(createdAt, status).WithArchiveRouting("createdAt", func() time.Time { return cutoff })
+
+	for q, expected := range map[string]bool{
+		"createdAt=gte:2024-02-01T00:00:00Z":                          false,
+		"createdAt=gte:2023-06-01T00:00:00Z,lt:2024-02-01T00:00:00Z": true,
+		"createdAt=lt:2024-02-01T00:00:00Z":                           true,
+		"createdAt=in:2024-02-01T00:00:00Z,2023-02-01T00:00:00Z":      true,
+		"status=open":                                                 false,
+	} {
+		qs, _ := url.ParseQuery(q)
+		result, err := qproc.Process(qs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TargetsArchive != expected {
+			t.Fatalf("%s: expected TargetsArchive to be %v", q, expected)
+		}
+	}
+
+	// derived processors check the path of their own override
+	createdAt.UseDBKey("meta.created")
+	qs, _ := url.ParseQuery("createdAt=lt:2024-02-01T00:00:00Z")
+	if result, _ := qproc.Derive(createdAt).Process(qs); !result.TargetsArchive {
+		t.Fatal("expected the derived processor to route to the archive")
+	}
+}
+
+func TestShardKey(t *testing.T) {
+	tenant := NewQField("tenant")
+	name := NewQField("name")
+	qproc := NewQueryProcessor(tenant, name).WithShardKey("tenant").TrackUsage()
+
+	qs, _ := url.ParseQuery("tenant=in:a,b&name=x")
+	result, _ := qproc.Process(qs)
+	if len(result.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", result.Warnings)
+	}
+	qs, _ = url.ParseQuery("tenant=ne:a&name=x")
+	result, _ = qproc.Process(qs)
+	if len(result.Warnings) != 1 || result.Warnings[0].Key != "tenant" {
+		t.Fatalf("expected a shard key warning, got %v", result.Warnings)
+	}
+	if usage := qproc.Usage(); usage.Queries != 2 || usage.ScatterGather != 1 {
+		t.Fatalf("expected one scatter-gather query, got %+v", usage)
+	}
+
+	// derived processors check the path of their own override
+	tenant.UseDBKey("org.tenant")
+	qs, _ = url.ParseQuery("tenant=a")
+	if result, _ := qproc.Derive(tenant).Process(qs); len(result.Warnings) != 0 {
+		t.Fatalf("expected no shard key warning from the derived processor, got %v", result.Warnings)
+	}
+}
+
+// sessionKey - Context key standing in for the driver's session
+type sessionKey struct{}
+
+// sessionCollection - QCollection that records the session found in the context of each call
+type sessionCollection struct {
+	sessions []interface{}
+}
+
+func (c *sessionCollection) Find(ctx context.Context, r QResult) ([]bson.M, error) {
+	c.sessions = append(c.sessions, ctx.Value(sessionKey{}))
+	return []bson.M{}, nil
+}
+
+func (c *sessionCollection) Count(ctx context.Context, r QResult) (int64, error) {
+	c.sessions = append(c.sessions, ctx.Value(sessionKey{}))
+	return 0, nil
+}
+
+func TestExecutorSession(t *testing.T) {
+	coll := &sessionCollection{}
+	exec := NewQExecutor(NewQueryProcessor(NewQField("name")), coll)
+	ctx := context.WithValue(context.Background(), sessionKey{}, "session")
+	if _, err := exec.List(ctx, url.Values{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Count(ctx, NewQResult()); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(coll.sessions) != "[session session]" {
+		t.Fatalf("expected the session to be passed to the collection, got %v", coll.sessions)
+	}
+}
+
+// labeledErr - Error with driver style error labels
+type labeledErr struct {
+	label string
+}
+
+func (e labeledErr) Error() string {
+	return e.label
+}
+
+func (e labeledErr) HasErrorLabel(label string) bool {
+	return e.label == label
+}
+
+// flakyCollection - QCollection that fails with the queued errors before succeeding
+type flakyCollection struct {
+	errs []error
+	calls int
+}
+
+func (c *flakyCollection) Find(ctx context.Context, r QResult) ([]bson.M, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return []bson.M{{"name": "a"}}, nil
+}
+
+func (c *flakyCollection) Count(ctx context.Context, r QResult) (int64, error) {
+	docs, err := c.Find(ctx, r)
+	return int64(len(docs)), err
+}
+
+func TestExecutorRetryPolicy(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name"))
+	policy := QRetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+
+	coll := &flakyCollection{errs: []error{labeledErr{"NetworkError"}, labeledErr{"RetryableReadError"}}}
+	docs, err := NewQExecutor(qproc, coll).WithRetryPolicy(policy).Find(context.Background(), NewQResult())
+	if err != nil || len(docs) != 1 || coll.calls != 3 {
+		t.Fatalf("expected success on the third attempt, got %v %v after %d calls", docs, err, coll.calls)
+	}
+
+	coll = &flakyCollection{errs: []error{labeledErr{"NetworkError"}, labeledErr{"NetworkError"}, labeledErr{"NetworkError"}}}
+	if _, err := NewQExecutor(qproc, coll).WithRetryPolicy(policy).Count(context.Background(), NewQResult()); err == nil || coll.calls != 3 {
+		t.Fatalf("expected an error after 2 retries, got %v after %d calls", err, coll.calls)
+	}
+
+	coll = &flakyCollection{errs: []error{errors.New("not transient")}}
+	if _, err := NewQExecutor(qproc, coll).WithRetryPolicy(policy).Find(context.Background(), NewQResult()); err == nil || coll.calls != 1 {
+		t.Fatalf("expected no retries of other errors, got %v after %d calls", err, coll.calls)
+	}
+}
+
+// mapCache - QCache backed by a map that records the ttl of each value
+type mapCache struct {
+	values map[string]interface{}
+	ttls map[string]time.Duration
+}
+
+func (c *mapCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *mapCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) {
+	c.values[key] = value
+	c.ttls[key] = ttl
+}
+
+func TestExecutorCache(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name"), NewQField("tag"))
+	coll := &fakeCollection{docs: []bson.M{{"name": "a"}}}
+	cache := &mapCache{values: map[string]interface{}{}, ttls: map[string]time.Duration{}}
+	exec := NewQExecutor(qproc, coll).WithCache(cache, "items", time.Minute)
+
+	for _, q := range []string{"name=a&tag=b", "tag=b&name=a"} {
+		qs, _ := url.ParseQuery(q)
+		if envelope, err := exec.List(context.Background(), qs); err != nil || len(envelope.Items) != 1 {
+			t.Fatalf("expected one document, got %v %v", envelope, err)
+		}
+	}
+	if len(coll.results) != 1 || len(cache.values) != 1 {
+		t.Fatalf("expected the second query to be served from the cache, got %d finds and %d cached values", len(coll.results), len(cache.values))
+	}
+	for key, ttl := range cache.ttls {
+		if !strings.HasPrefix(key, "items:find:") || ttl != time.Minute {
+			t.Fatalf("expected an items find key with the ttl, got %s %v", key, ttl)
+		}
+	}
+}
+
+func TestCollectionResolver(t *testing.T) {
+	type tenantKey struct{}
+	tenants := map[string]*fakeCollection{
+		"a": {docs: []bson.M{{"name": "a"}}},
+		"b": {docs: []bson.M{{"name": "b"}, {"name": "c"}}},
+	}
+	resolver := func(ctx context.Context) (QCollection, string, error) {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		coll, ok := tenants[tenant]
+		if !ok {
+			return nil, "", errors.New("unknown tenant")
+		}
+		return coll, tenant + ".items", nil
+	}
+	cache := &mapCache{values: map[string]interface{}{}, ttls: map[string]time.Duration{}}
+	exec := NewQExecutorWithResolver(NewQueryProcessor(NewQField("name")), resolver).WithCache(cache, "items", time.Minute)
+
+	for tenant, n := range map[string]int{"a": 1, "b": 2} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		if envelope, err := exec.List(ctx, url.Values{}); err != nil || len(envelope.Items) != n {
+			t.Fatalf("%s: expected %d documents, got %v %v", tenant, n, envelope, err)
+		}
+	}
+	for key := range cache.values {
+		if !strings.HasPrefix(key, "a.items:") && !strings.HasPrefix(key, "b.items:") {
+			t.Fatalf("expected cache keys to use the resolved collection names, got %s", key)
+		}
+	}
+	if len(cache.values) != 2 {
+		t.Fatalf("expected the results of each tenant to be cached separately, got %v", cache.values)
+	}
+	if _, err := exec.Count(context.Background(), NewQResult()); err == nil {
+		t.Fatal("expected the resolver error")
+	}
+}
+
+func TestETag(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name"))
+	qs, _ := url.ParseQuery("name=a")
+	result, _ := qproc.Process(qs)
+	etag := ETag(result, "v1")
+	if etag != ETag(result, "v1") || etag == ETag(result, "v2") || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("expected a quoted ETag that changes with the version, got %s", etag)
+	}
+	for header, expected := range map[string]bool{
+		etag:                   true,
+		"W/" + etag:            true,
+		`"other", ` + etag:     true,
+		"*":                    true,
+		`"other"`:              false,
+		"":                     false,
+	} {
+		if NotModified(header, etag) != expected {
+			t.Fatalf("%q: expected NotModified to be %v", header, expected)
+		}
+	}
+}
+
+func TestActivation(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseDefault(func() string { return "gte:2024-01-01T00:00:00Z" }).UseActivation("groupBy")
+	name := NewQField("name")
+	name.UseInterceptor(func(op string, value interface{}) (interface{}, error) {
+		return strings.ToLower(value.(string)), nil
+	}).UseActivation("normalize")
+	groupBy := NewQField("groupBy")
+	groupBy.ParseAsMeta()
+	qproc := NewQueryProcessor(createdAt, name, groupBy)
+
+	qs, _ := url.ParseQuery("name=Alice")
+	result, _ := qproc.Process(qs)
+	if _, ok := result.Filter["createdAt"]; ok || fmt.Sprint(result.Filter["name"]) != "map[$eq:Alice]" {
+		t.Fatalf("expected no default and no interceptor, got %v", result.Filter)
+	}
+
+	qs, _ = url.ParseQuery("name=Alice&groupBy=day&normalize=true")
+	result, _ = qproc.Process(qs)
+	if _, ok := result.Filter["createdAt"]; !ok || fmt.Sprint(result.Filter["name"]) != "map[$eq:alice]" {
+		t.Fatalf("expected the default and the interceptor, got %v", result.Filter)
+	}
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	for precedence, expected := range map[QMatchPrecedence]string{
+		MatchCombined:     "map[$eq:Alice $options:i $regex:^al]",
+		MatchPreferExact:  "map[$eq:Alice]",
+		MatchPreferSearch: "map[$options:i $regex:^al]",
+		MatchExclusive:    "map[$eq:Alice]",
+	} {
+		name := NewQField("name")
+		name.UseMatchPrecedence(precedence)
+		qs, _ := url.ParseQuery("name=Alice,slike:al")
+		result, err := NewQueryProcessor(name).Process(qs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(result.Filter["name"]) != expected {
+			t.Fatalf("%d: expected %s, got %v", precedence, expected, result.Filter["name"])
+		}
+	}
+
+	name := NewQField("name")
+	name.UseMatchPrecedence(MatchExclusive).UseFailurePolicy(PolicyFailRequest)
+	qs, _ := url.ParseQuery("name=Alice,like:al")
+	if _, err := NewQueryProcessor(name).Process(qs); !errors.Is(err, ErrValueNotValid) {
+		t.Fatalf("expected ErrValueNotValid, got %v", err)
+	}
+}
+
+func TestCombinedRegularExpressions(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name"))
+	qs, _ := url.ParseQuery("name=slike:al,elike:ce,like:li")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[$and:[map[name:map[$options:i $regex:ce$]] map[name:map[$options:i $regex:li]]] name:map[$options:i $regex:^al]]" {
+		t.Fatalf("expected every regular expression to be applied, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"name": "Alice"}) || match(bson.M{"name": "Alan"}) {
+		t.Fatal("expected Alice to match and Alan not to match")
+	}
+}
+
+func TestParsed(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseDBKey("meta.created")
+	count := NewQField("count")
+	count.ParseAsInt()
+	qproc := NewQueryProcessor(createdAt, count)
+
+	qs, _ := url.ParseQuery("count=lt:10,gt:1&createdAt=gte:2024-01-01T00:00:00Z")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clauses := result.Parsed["count"]
+	if len(clauses) != 2 || clauses[0].Op != lt || clauses[0].Values[0] != "10" || clauses[0].Value != int64(10) || clauses[1].Op != gt {
+		t.Fatalf("expected the lt: and gt: clauses in order, got %+v", clauses)
+	}
+	if len(result.Parsed["meta.created"]) != 1 {
+		t.Fatalf("expected the createdAt clauses under its document path, got %v", result.Parsed)
+	}
+}
+
+func TestRender(t *testing.T) {
+	count := NewQField("count")
+	count.ParseAsInt()
+	qs, _ := url.ParseQuery("count=gt:1&lmt=5")
+	result, _ := NewQueryProcessor(count).Process(qs)
+
+	if compact := result.Render(FormatCompact); compact != `filter={"count":{"$gt":1}} projection={} sort={} limit=5 skip=0 meta={} warnings=[]` {
+		t.Fatalf("unexpected compact rendering %s", compact)
+	}
+	if ext := result.Render(FormatExtJSON); !strings.Contains(ext, `"$gt":{"$numberLong":"1"}`) {
+		t.Fatalf("expected canonical extended JSON, got %s", ext)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(result.Render(FormatJSON)), &doc); err != nil || doc["limit"] != float64(5) {
+		t.Fatalf("expected valid JSON, got %v %v", doc, err)
+	}
+	if result.String() != result.Render(FormatText) {
+		t.Fatal("expected String to use FormatText")
+	}
+}
+
+func TestFormatVerbs(t *testing.T) {
+	result := NewQResult()
+	result.Limit = 5
+	if s := fmt.Sprintf("%+v", &result); s != result.Render(FormatCompact) {
+		t.Fatalf("expected %%+v to use FormatCompact, got %s", s)
+	}
+	if s := fmt.Sprintf("%#v", &result); s != result.Render(FormatExtJSON) {
+		t.Fatalf("expected %%#v to use FormatExtJSON, got %s", s)
+	}
+	if s := fmt.Sprintf("%v", &result); s != result.String() {
+		t.Fatalf("expected %%v to use FormatText, got %s", s)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	email := NewQField("email")
+	email.UseAliases("mail").PII()
+	status := NewQField("status")
+	qproc := NewQueryProcessor(email, status).RedactPII()
+
+	qs, _ := url.ParseQuery("mail=in:a@example.com,b@example.com&status=open")
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsRedacted || fmt.Sprint(result.Filter["email"]) != "map[$in:[a@example.com b@example.com]]" {
+		t.Fatalf("expected the Filter to be unchanged, got %v", result.Filter)
+	}
+	rendered := result.Render(FormatCompact)
+	if strings.Contains(rendered, "example.com") || !strings.Contains(rendered, `"email":{"$in":"[REDACTED]"}`) || !strings.Contains(rendered, `"status":{"$eq":"open"}`) {
+		t.Fatalf("expected only the email values to be masked, got %s", rendered)
+	}
+	redacted := result.Redacted()
+	if redacted.Parsed["email"][0].Value != "[REDACTED]" || result.Parsed["email"][0].Value == "[REDACTED]" {
+		t.Fatalf("expected only the copy's parsed clauses to be masked, got %v", redacted.Parsed)
+	}
+
+	email.UseFailurePolicy(PolicyDropField)
+	result, _ = NewQueryProcessor(email).RedactPII().Process(url.Values{"email": {"eq:alice@example.com,bitsallset:alice@example.com"}})
+	if rendered := result.Render(FormatText); len(result.Warnings) != 1 || strings.Contains(rendered, "alice@example.com") || !strings.Contains(rendered, `operator "bitsallset:" on field "email"`) {
+		t.Fatalf("expected invalid PII values to be masked in warning messages, got %s", rendered)
+	}
+}
+
+func TestPageRequest(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().Sortable().UseDBKey("meta.created")
+	name := NewQField("name")
+	name.Sortable()
+	qproc := NewQueryProcessor(createdAt, name)
+
+	qs, _ := url.ParseQuery("srt=-createdAt,name&lmt=20&skp=40")
+	result, _ := qproc.Process(qs)
+	page := result.PageRequest()
+	b, _ := json.Marshal(page)
+	if string(b) != `{"limit":20,"skip":40,"sort":[{"key":"createdAt","descending":true},{"key":"name"}]}` {
+		t.Fatalf("unexpected page request %s", b)
+	}
+
+	again, _ := qproc.Process(page.Query())
+	if fmt.Sprint(again.PageRequest()) != fmt.Sprint(page) {
+		t.Fatalf("expected the page request query to round trip, got %v", again.PageRequest())
+	}
+}
+
+func TestAttach(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("status"))
+	qs, _ := url.ParseQuery("status=open")
+	result, _ := qproc.Process(qs)
+
+	if err := result.Attach("tenant", bson.M{"tenant": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Attach("tenant", bson.M{"tenant": bson.M{"$in": bson.A{"b", "c"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[$and:[map[tenant:map[$in:[b c]]]] status:map[$eq:open]]" {
+		t.Fatalf("expected the tenant slot to be replaced, got %v", result.Filter)
+	}
+
+	deep := bson.M{"a": 1}
+	for i := 0; i < 12; i++ {
+		deep = bson.M{"$and": bson.A{deep}}
+	}
+	for _, fragment := range []bson.M{
+		{"$where": "this.a == 1"},
+		{"a": bson.M{"$elemMatch": bson.M{"$expr": true}}},
+		deep,
+	} {
+		if err := result.Attach("bad", fragment); !errors.Is(err, ErrFragmentNotValid) {
+			t.Fatalf("expected ErrFragmentNotValid for %v, got %v", fragment, err)
+		}
+	}
+	if len(result.Filter["$and"].(bson.A)) != 1 {
+		t.Fatalf("expected rejected fragments not to be attached, got %v", result.Filter)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	owner := NewQField("owner")
+	owner.UseVisibilityFilter(func(ctx context.Context) bson.M {
+		return bson.M{"$where": "this.public"}
+	})
+	qproc := NewQueryProcessor(owner, NewQField("name"))
+
+	qs, _ := url.ParseQuery("name=a")
+	if _, err := qproc.Process(qs); err != nil {
+		t.Fatal(err)
+	}
+	qs, _ = url.ParseQuery("owner=me")
+	if _, err := qproc.Process(qs); !errors.Is(err, ErrFilterNotSafe) {
+		t.Fatalf("expected ErrFilterNotSafe, got %v", err)
+	}
+	if err := Sanitize(bson.M{"a": bson.M{"$function": bson.M{}}}); !errors.Is(err, ErrFilterNotSafe) {
+		t.Fatalf("expected ErrFilterNotSafe, got %v", err)
+	}
+}
+
+func TestValueCounts(t *testing.T) {
+	id := NewQField("id")
+	id.ParseAsObjectID()
+	count := NewQField("count")
+	count.ParseAsInt()
+	for _, qproc := range []*QProcessor{NewQueryProcessor(id, count), NewQueryProcessor(id, count).WithParallelism(2)} {
+		qs, _ := url.ParseQuery("id=in:6050e7f529a90b22dc47f19e,bad,6050e7f529a90b22dc47f19f,worse,6050e7f529a90b22dc47f1a0&count=gt:1")
+		result, err := qproc.Process(qs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ValueCounts["id"] != (QValueCounts{Valid: 3, Invalid: 2}) || result.ValueCounts["count"] != (QValueCounts{Valid: 1}) {
+			t.Fatalf("expected 3 of 5 ids to be valid, got %v", result.ValueCounts)
+		}
+	}
+}
+
+func TestAllOrNothingLists(t *testing.T) {
+	id := NewQField("id")
+	id.ParseAsObjectID().AllOrNothingLists()
+	qs, _ := url.ParseQuery("id=in:6050e7f529a90b22dc47f19e,bad")
+
+	result, err := NewQueryProcessor(id).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Filter["id"]; ok || result.ValueCounts["id"] != (QValueCounts{Invalid: 2}) {
+		t.Fatalf("expected the whole in: clause to be dropped, got %v %v", result.Filter, result.ValueCounts)
+	}
+
+	if _, err := NewQueryProcessor(id).Strict().Process(qs); !errors.Is(err, ErrValueNotValid) {
+		t.Fatalf("expected ErrValueNotValid from a strict processor, got %v", err)
+	}
+
+	qs, _ = url.ParseQuery("id=in:6050e7f529a90b22dc47f19e,6050e7f529a90b22dc47f19f")
+	if result, _ := NewQueryProcessor(id).Process(qs); result.Filter["id"] == nil {
+		t.Fatal("expected valid lists to be kept")
+	}
+}
+
+func TestEmptyArray(t *testing.T) {
+	tags := NewQField("tags")
+	qs, _ := url.ParseQuery("tags=emptyarray:")
+	result, err := NewQueryProcessor(tags).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[tags:map[$eq:[]]]" {
+		t.Fatalf("expected an empty array filter, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"tags": bson.A{}}) || match(bson.M{"tags": bson.A{"a"}}) || match(bson.M{}) {
+		t.Fatal("expected only documents with an empty tags array to match")
+	}
+
+	qs, _ = url.ParseQuery("tags=emptyarray:a")
+	if result, _ := NewQueryProcessor(tags).Process(qs); len(result.Filter) != 0 {
+		t.Fatalf("expected values to be invalid, got %v", result.Filter)
+	}
+
+	qs, _ = url.ParseQuery("tags=emptyarray:")
+	result, _ = NewQueryProcessor(tags).WithSyntax(SyntaxV3).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[tags:map[$eq:emptyarray:]]" {
+		t.Fatalf("expected SyntaxV3 to treat the operator as a value, got %v", result.Filter)
+	}
+}
+
+func TestFold(t *testing.T) {
+	email := NewQField("email")
+	email.Foldable()
+	name := NewQField("name")
+	qproc := NewQueryProcessor(email, name)
+
+	qs, _ := url.ParseQuery("email=in:Ann@Example.com,BOB@example.com&name=Ann&fld=lower")
+	result, _ := qproc.Process(qs)
+	if fmt.Sprint(result.Filter) != "map[email:map[$in:[ann@example.com bob@example.com]] name:map[$eq:Ann]]" {
+		t.Fatalf("expected only the foldable field to be folded, got %v", result.Filter)
+	}
+
+	qs, _ = url.ParseQuery("email=Ann@Example.com&fld=title")
+	result, _ = qproc.Process(qs)
+	if fmt.Sprint(result.Filter["email"]) != "map[$eq:Ann@Example.com]" || len(result.Warnings) != 1 {
+		t.Fatalf("expected unknown folds to be ignored with a warning, got %v %v", result.Filter, result.Warnings)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	qs, _ := url.ParseQuery("deletedAt=null&owner=ne:null")
+	deletedAt := NewQField("deletedAt")
+	deletedAt.ParseAsDateTime()
+	owner := NewQField("owner")
+
+	result, _ := NewQueryProcessor(deletedAt, owner).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[owner:map[$ne:null]]" {
+		t.Fatalf("expected null to be a value without keywords, got %v", result.Filter)
+	}
+
+	result, _ = NewQueryProcessor(deletedAt, owner).WithFeatures(QFeatures{EnableKeywords: true}).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[deletedAt:map[$eq:{}] owner:map[$ne:{}]]" {
+		t.Fatalf("expected null keywords, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"owner": "a"}) || !match(bson.M{"owner": "a", "deletedAt": nil}) || match(bson.M{}) || match(bson.M{"owner": "a", "deletedAt": primitive.NewDateTimeFromTime(time.Now())}) {
+		t.Fatal("expected null to match null and missing fields")
+	}
+}
+
+func TestBrackets(t *testing.T) {
+	qs, _ := url.ParseQuery("tag[]=a&tag[]=b&n[]=1&n[]=x")
+	tag := NewQField("tag")
+	n := NewQField("num")
+	n.ParseAsInt().UseAliases("n")
+
+	result, _ := NewQueryProcessor(tag, n).Process(qs)
+	if len(result.Filter) != 0 {
+		t.Fatalf("expected bracketed keys to be ignored by default, got %v", result.Filter)
+	}
+
+	qproc := NewQueryProcessor(tag, n).WithFeatures(QFeatures{EnableBrackets: true})
+	result, _ = qproc.Process(qs)
+	if fmt.Sprint(result.Filter) != "map[num:map[$in:[1]] tag:map[$in:[a b]]]" {
+		t.Fatalf("expected in: lists from bracketed keys, got %v", result.Filter)
+	}
+	qs.Set("tag", "c")
+	result, _ = qproc.Process(qs)
+	if fmt.Sprint(result.Filter["tag"]) != "map[$eq:c]" {
+		t.Fatalf("expected the key to take precedence over the bracketed key, got %v", result.Filter)
+	}
+}
+
+func TestMergePolicy(t *testing.T) {
+	qs, _ := url.ParseQuery("myInt=gte:1&n=lte:5")
+	myInt := NewQField("myInt")
+	myInt.ParseAsInt().UseAliases("n")
+
+	result, _ := NewQueryProcessor(myInt).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[myInt:map[$gte:1]]" || len(result.Warnings) != 1 || result.Warnings[0].Key != "n" {
+		t.Fatalf("expected the key to be used and the alias to be ignored with a warning, got %v %v", result.Filter, result.Warnings)
+	}
+
+	myInt.UseMergePolicy(MergeCombine)
+	result, _ = NewQueryProcessor(myInt).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[myInt:map[$gte:1 $lte:5]]" || len(result.Warnings) != 0 {
+		t.Fatalf("expected the clauses to be combined, got %v %v", result.Filter, result.Warnings)
+	}
+
+	// strict processors leave the key and aliases to the merge policy
+	result, err := NewQueryProcessor(myInt).Strict().Process(qs)
+	if err != nil || fmt.Sprint(result.Filter) != "map[myInt:map[$gte:1 $lte:5]]" {
+		t.Fatalf("expected the strict processor to combine the clauses, got %v %v", result.Filter, err)
+	}
+	qs2, _ := url.ParseQuery("myInt=gte:1&myInt=lte:5")
+	if _, err := NewQueryProcessor(myInt).Strict().Process(qs2); err == nil {
+		t.Fatal("expected a repeated key to be rejected by the strict processor")
+	}
+
+	myInt.UseMergePolicy(MergeReject)
+	if _, err := NewQueryProcessor(myInt).Process(qs); !errors.Is(err, ErrValueConflict) {
+		t.Fatalf("expected ErrValueConflict but got %v", err)
+	}
+}
+
+func TestLearn(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name")).Learn()
+	for _, q := range []string{"name=a&color=red&lmt=5", "color=blue&size=m", "nme=b"} {
+		qs, _ := url.ParseQuery(q)
+		if result, _ := qproc.Process(qs); len(result.Filter) > 1 {
+			t.Fatalf("expected undeclared keys not to be applied, got %v", result.Filter)
+		}
+	}
+	if learned := qproc.Learned(); fmt.Sprint(learned) != "[{color 2} {nme 1} {size 1}]" {
+		t.Fatalf("expected undeclared keys ordered by use, got %v", learned)
+	}
+	if learned := NewQueryProcessor(NewQField("name")).Learned(); len(learned) != 0 {
+		t.Fatalf("expected no learned keys without learning mode, got %v", learned)
+	}
+}
+
+func TestVirtualField(t *testing.T) {
+	qproc := NewQueryProcessor(NewQField("name")).
+		WithVirtualField("score", func(ctx context.Context, query url.Values) (QVirtual, bool) {
+			return QVirtual{Meta: "textScore"}, query.Get("q") != ""
+		}).
+		WithVirtualField("nameLength", func(ctx context.Context, query url.Values) (QVirtual, bool) {
+			return QVirtual{Expr: bson.M{"$strLenCP": "$name"}}, true
+		})
+
+	qs, _ := url.ParseQuery("q=a&srt=score,-nameLength&prj=name,score")
+	result, _ := qproc.Process(qs)
+	if fmt.Sprint(result.Sort) != "[{score map[$meta:textScore]} {nameLength -1}]" {
+		t.Fatalf("expected a $meta sort and a computed sort, got %v", result.Sort)
+	}
+	if fmt.Sprint(result.Projection["score"]) != "map[$meta:textScore]" || fmt.Sprint(result.AddFields) != "map[nameLength:map[$strLenCP:$name]]" {
+		t.Fatalf("expected the score projection and the nameLength expression, got %v %v", result.Projection, result.AddFields)
+	}
+
+	qs, _ = url.ParseQuery("srt=score&prj=score")
+	result, _ = qproc.Process(qs)
+	if len(result.Sort) != 0 || result.Projection["score"] != nil || len(result.Warnings) != 1 {
+		t.Fatalf("expected the unavailable field to be ignored with one warning, got %v %v %v", result.Sort, result.Projection, result.Warnings)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	name := NewQField("name")
+	name.Sortable()
+	qs, _ := url.ParseQuery("name=like:a&lmt=500")
+
+	if _, err := NewReadOnlyListProcessor(name).Process(qs); !errors.Is(err, ErrOperatorNotAllowed) {
+		t.Fatalf("expected ErrOperatorNotAllowed but got %v", err)
+	}
+	result, err := NewAdminSearchProcessor(name).Process(qs)
+	if err != nil || result.Limit != 500 || fmt.Sprint(result.Sort) != "[{_id -1}]" {
+		t.Fatalf("expected the admin limit and default sort, got %v %v %v", result.Limit, result.Sort, err)
+	}
+	qs, _ = url.ParseQuery("srt=name&lmt=500")
+	result, _ = NewReadOnlyListProcessor(name).Process(qs)
+	if result.Limit != 100 || fmt.Sprint(result.Sort) != "[{name 1}]" {
+		t.Fatalf("expected the hard cap and the requested sort, got %v %v", result.Limit, result.Sort)
+	}
+	sku := NewQField("sku")
+	sku.AllowRegex(QRegexLimits{})
+	qs, _ = url.ParseQuery("sku=re:^A[0-9]+")
+	if _, err := NewReadOnlyListProcessor(sku).Process(qs); !errors.Is(err, ErrOperatorNotAllowed) {
+		t.Fatalf("expected re: to be restricted but got %v", err)
+	}
+}
+
+func TestTimeLayout(t *testing.T) {
+	createdAt := NewQField("createdAt")
+	createdAt.ParseAsDateTime().UseTimeLayout("2006-01-02", time.RFC822, LayoutUnixMillis)
+	want := primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+
+	for _, v := range []string{"2024-01-02", "02 Jan 24 00:00 UTC", "1704153600000"} {
+		clauses, err := createdAt.Parse("gte:" + v)
+		if err != nil || len(clauses) != 1 || clauses[0].Value != want {
+			t.Fatalf("%s: expected %v, got %v %v", v, want, clauses, err)
+		}
+	}
+	if clauses, _ := createdAt.Parse("gte:2024-01-02T00:00:00Z"); len(clauses) != 0 {
+		t.Fatalf("expected RFC3339 to be rejected when it is not a layout, got %v", clauses)
+	}
+	createdAt.UseTimeLayout(time.RFC1123)
+	if clauses, err := createdAt.Parse("gte:Tue, 02 Jan 2024 00:00:00 UTC"); err != nil || len(clauses) != 1 || clauses[0].Value != want {
+		t.Fatalf("expected RFC1123 values to be parsed with their comma, got %v %v", clauses, err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	deletedAt := NewQField("deletedAt")
+	deletedAt.ParseAsDateTime()
+	qs, _ := url.ParseQuery("deletedAt=exists:false")
+	result, err := NewQueryProcessor(deletedAt).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[deletedAt:map[$exists:false]]" {
+		t.Fatalf("expected an $exists filter, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{}) || match(bson.M{"deletedAt": nil}) {
+		t.Fatal("expected only documents without the field to match")
+	}
+
+	qs, _ = url.ParseQuery("deletedAt=exists:maybe")
+	if result, _ := NewQueryProcessor(deletedAt).Process(qs); len(result.Filter) != 0 {
+		t.Fatalf("expected values that are not bools to be invalid, got %v", result.Filter)
+	}
+
+	name := NewQField("name")
+	qs, _ = url.ParseQuery("name=exists:true")
+	result, _ = NewQueryProcessor(name).WithSyntax(SyntaxV4).Process(qs)
+	if fmt.Sprint(result.Filter) != "map[name:map[$eq:exists:true]]" {
+		t.Fatalf("expected SyntaxV4 to treat the operator as a value, got %v", result.Filter)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	price := NewQField("price")
+	price.ParseAsInt()
+	created := NewQField("created")
+	created.ParseAsDateTime()
+	name := NewQField("name")
+	qs, _ := url.ParseQuery("price=between:10,100&created=between:2021-01-01T00:00:00Z,2021-02-01T00:00:00Z&name=between:a,b")
+	result, err := NewQueryProcessor(price, created, name).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter["price"]) != "map[$gte:10 $lte:100]" || len(result.Filter["created"].(bson.M)) != 2 {
+		t.Fatalf("expected $gte and $lte filters, got %v", result.Filter)
+	}
+	if _, ok := result.Filter["name"]; ok {
+		t.Fatalf("expected between: to be invalid for string fields, got %v", result.Filter)
+	}
+
+	qs, _ = url.ParseQuery("price=between:10")
+	if result, _ := NewQueryProcessor(price).Process(qs); len(result.Filter) != 0 {
+		t.Fatalf("expected one value to be invalid, got %v", result.Filter)
+	}
+}
+
+func TestNegation(t *testing.T) {
+	name := NewQField("name")
+	age := NewQField("age")
+	age.ParseAsInt()
+	qs, _ := url.ParseQuery("name=not:like:foo,!slike:b&age=!in:1,2")
+	result, err := NewQueryProcessor(name, age).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[$and:[map[name:map[$not:map[$options:i $regex:^b]]]] age:map[$not:map[$in:[1 2]]] name:map[$not:map[$options:i $regex:foo]]]" {
+		t.Fatalf("expected negated operators wrapped in $not, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"name": "abc", "age": 3}) || !match(bson.M{}) || match(bson.M{"name": "afoo"}) || match(bson.M{"age": 2}) {
+		t.Fatal("expected documents matching a negated operator not to match")
+	}
+
+	if _, err := NewQueryProcessor(name).RestrictOperator(like).Process(qs); !errors.Is(err, ErrOperatorNotAllowed) {
+		t.Fatalf("expected negations of restricted operators to be restricted, got %v", err)
+	}
+
+	result, _ = NewQueryProcessor(name).WithSyntax(SyntaxV6).Process(url.Values{"name": {"!like:foo"}})
+	// syntax versions before SyntaxV10 detect like: anywhere, so the ! is a value
+	if fmt.Sprint(result.Filter) != "map[name:map[$eq:! $options:i $regex:foo]]" {
+		t.Fatalf("expected SyntaxV6 to treat negation prefixes as values, got %v", result.Filter)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	name := NewQField("name")
+	email := NewQField("email")
+	age := NewQField("age")
+	age.ParseAsInt().UseAliases("years")
+	qproc := NewQueryProcessor(name, email, age)
+
+	qs := url.Values{"or": {"name:like:smith;email:like:smith"}, "nor": {"years:gt:60;age:x;color:red"}}
+	result, err := qproc.Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[$and:[map[$or:[map[name:map[$options:i $regex:smith]] map[email:map[$options:i $regex:smith]]]] map[$nor:[map[age:map[$gt:60]]]]]]" {
+		t.Fatalf("expected $or and $nor groups, got %v", result.Filter)
+	}
+	if len(result.Warnings) != 2 {
+		t.Fatalf("expected warnings for the invalid and unknown branches, got %v", result.Warnings)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"email": "a.smith@example.com", "age": 30}) || match(bson.M{"name": "smith", "age": 70}) || match(bson.M{"name": "jones"}) {
+		t.Fatal("expected documents to match one or branch and no nor branch")
+	}
+
+	if _, err := NewQueryProcessor(name, email).RestrictOperator(like).Process(qs); !errors.Is(err, ErrOperatorNotAllowed) {
+		t.Fatalf("expected restricted operators to be enforced in branches, got %v", err)
+	}
+
+	email.PII().UseFailurePolicy(PolicyDropField)
+	result, _ = NewQueryProcessor(name, email).RedactPII().Process(url.Values{"or": {"email:eq:bob@example.com,bitsallset:1"}})
+	if rendered := result.Render(FormatCompact); len(result.Warnings) != 1 || strings.Contains(rendered, "bob@example.com") {
+		t.Fatalf("expected branch warnings of PII fields to be masked, got %s", rendered)
+	}
+}
+
+func TestRegex(t *testing.T) {
+	sku := NewQField("sku")
+	sku.AllowRegex(QRegexLimits{Denied: []string{".*"}})
+	name := NewQField("name")
+	qproc := NewQueryProcessor(sku, name)
+
+	result, _ := qproc.Process(url.Values{"sku": {"re:^AB-[0-9]{4}$"}, "name": {"re:^a"}})
+	if fmt.Sprint(result.Filter) != "map[sku:map[$regex:^AB-[0-9]{4}$]]" {
+		t.Fatalf("expected re: to only be allowed on regex fields, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"sku": "AB-1234"}) || match(bson.M{"sku": "ab-1234"}) {
+		t.Fatal("expected the pattern to match case-sensitively")
+	}
+
+	for _, pattern := range []string{"(a+)+$", "a.*b", "(a)\\1", "a?b?c?d?e?", strings.Repeat("a", 65)} {
+		if result, _ := qproc.Process(url.Values{"sku": {"re:" + pattern}}); len(result.Filter) != 0 {
+			t.Fatalf("%s: expected the pattern to be rejected, got %v", pattern, result.Filter)
+		}
+	}
+}
+
+func TestGeo(t *testing.T) {
+	location := NewQField("location")
+	location.ParseAsGeo()
+	qproc := NewQueryProcessor(location)
+
+	result, err := qproc.Process(url.Values{"location": {"near:-73.97,40.77,5000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[location:map[$near:map[$geometry:map[coordinates:[-73.97 40.77] type:Point] $maxDistance:5000]]]" {
+		t.Fatalf("expected a $near filter with a GeoJSON point, got %v", result.Filter)
+	}
+
+	result, _ = qproc.Process(url.Values{"location": {"within:box(-74,40,-73,41)"}})
+	if fmt.Sprint(result.Filter) != "map[location:map[$geoWithin:map[$geometry:map[coordinates:[[[-74 40] [-73 40] [-73 41] [-74 41] [-74 40]]] type:Polygon]]]]" {
+		t.Fatalf("expected a $geoWithin filter with a polygon, got %v", result.Filter)
+	}
+	result, _ = qproc.Process(url.Values{"location": {"within:poly(0,0,1,0,1,1)"}})
+	if ring := result.Filter["location"].(bson.M)["$geoWithin"].(bson.M)["$geometry"].(bson.M)["coordinates"].(bson.A)[0].(bson.A); len(ring) != 4 {
+		t.Fatalf("expected the polygon ring to be closed, got %v", ring)
+	}
+
+	for _, qvalue := range []string{"near:200,40", "near:1,2,-5", "near:1,2,10,20", "within:tri(0,0,1,1)", "within:poly(0,0,1,1)", "eq:1,2", "!in:1,2"} {
+		if result, _ := qproc.Process(url.Values{"location": {qvalue}}); len(result.Filter) != 0 {
+			t.Fatalf("%s: expected the value to be invalid, got %v", qvalue, result.Filter)
+		}
+	}
+
+	name := NewQField("name")
+	result, _ = NewQueryProcessor(name).Process(url.Values{"name": {"near:1,2"}})
+	if len(result.Filter) != 0 {
+		t.Fatalf("expected near: to be invalid for string fields, got %v", result.Filter)
+	}
+	result, _ = qproc.Process(url.Values{"or": {"location:near:1,2;location:within:circle(1,2,100)"}})
+	if fmt.Sprint(result.Filter) != "map[$and:[map[$or:[map[location:map[$geoWithin:map[$centerSphere:[[1 2] 1.5678650381775137e-05]]]]]]]]" || len(result.Warnings) != 1 {
+		t.Fatalf("expected near: branches to be ignored with a warning, got %v %v", result.Filter, result.Warnings)
+	}
+
+	coll := &fakeCollection{}
+	result, _ = qproc.Process(url.Values{"location": {"near:1,2,1000,10"}})
+	if _, err := NewQExecutor(qproc, coll).Count(context.Background(), result); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(coll.results[0].Filter) != "map[location:map[$geoWithin:map[$centerSphere:[[1 2] 0.00015678650381775137]] $not:map[$geoWithin:map[$centerSphere:[[1 2] 1.5678650381775136e-06]]]]]" || result.Filter["location"].(bson.M)["$near"] == nil {
+		t.Fatalf("expected near: to be counted with $geoWithin, got %v", coll.results[0].Filter)
+	}
+
+	result, _ = NewQueryProcessor(name).WithSyntax(SyntaxV8).Process(url.Values{"name": {"near:1"}})
+	if fmt.Sprint(result.Filter) != "map[name:map[$eq:near:1]]" {
+		t.Fatalf("expected SyntaxV8 to treat near: as a value, got %v", result.Filter)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	price := NewQField("price")
+	price.ParseAsDecimal()
+	qs, _ := url.ParseQuery("price=gte:19.99,lt:100.00,nin:25.50,abc")
+	result, err := NewQueryProcessor(price).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[price:map[$gte:19.99 $lt:100.00 $nin:[25.50]]]" {
+		t.Fatalf("expected Decimal128 comparisons, got %v", result.Filter)
+	}
+	if _, ok := result.Filter["price"].(bson.M)["$gte"].(primitive.Decimal128); !ok {
+		t.Fatalf("expected a Decimal128 value, got %T", result.Filter["price"].(bson.M)["$gte"])
+	}
+	if result.ValueCounts["price"].Invalid != 1 {
+		t.Fatalf("expected one invalid value, got %v", result.ValueCounts["price"])
+	}
+	match, _ := result.Match()
+	fifty, _ := primitive.ParseDecimal128("50")
+	if !match(bson.M{"price": fifty}) || match(bson.M{"price": 10.5}) {
+		t.Fatal("expected decimals to be compared as numbers")
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	ts := NewQField("ts")
+	ts.ParseAsTimestamp()
+	qs, _ := url.ParseQuery("ts=gt:1700000000,5,lte:1700000100")
+	result, err := NewQueryProcessor(ts).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := result.Filter["ts"].(bson.M)
+	if filter["$gt"] != (primitive.Timestamp{T: 1700000000, I: 5}) || filter["$lte"] != (primitive.Timestamp{T: 1700000100}) {
+		t.Fatalf("expected timestamp comparisons, got %v", result.Filter)
+	}
+	match, _ := result.Match()
+	if !match(bson.M{"ts": primitive.Timestamp{T: 1700000000, I: 6}}) || match(bson.M{"ts": primitive.Timestamp{T: 1700000000, I: 5}}) {
+		t.Fatal("expected timestamps to be ordered by seconds then increment")
+	}
+
+	result, _ = NewQueryProcessor(ts).Process(url.Values{"ts": {"in:1700000000,x"}})
+	if fmt.Sprint(result.Filter["ts"]) != "map[$in:[{1700000000 0}]]" {
+		t.Fatalf("expected list members to be seconds, got %v", result.Filter)
+	}
+	if result, _ := NewQueryProcessor(ts).Process(url.Values{"ts": {"gte:1,2,3"}}); len(result.Filter) != 0 {
+		t.Fatalf("expected more than seconds and increment to be invalid, got %v", result.Filter)
+	}
+	if FormatClause("gt", primitive.Timestamp{T: 1700000000, I: 5}) != "gt:1700000000,5" {
+		t.Fatalf("expected timestamps to be formatted as seconds,increment, got %s", FormatClause("gt", primitive.Timestamp{T: 1700000000, I: 5}))
+	}
+}
+
+func TestCustomParser(t *testing.T) {
+	statuses := map[string]int32{"active": 1, "suspended": 2, "closed": 3}
+	status := NewQField("status")
+	status.UseParser(func(raw string) (interface{}, error) {
+		if n, ok := statuses[raw]; ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%q is not a status", raw)
+	})
+	qproc := NewQueryProcessor(status)
+	result, err := qproc.Process(url.Values{"status": {"in:active,closed,unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Filter) != "map[status:map[$in:[1 3]]]" || result.ValueCounts["status"].Invalid != 1 {
+		t.Fatalf("expected values parsed by the custom parser, got %v", result.Filter)
+	}
+	result, _ = qproc.Process(url.Values{"status": {"between:active,suspended"}})
+	if fmt.Sprint(result.Filter) != "map[status:map[$gte:1 $lte:2]]" {
+		t.Fatalf("expected between: to use the custom parser, got %v", result.Filter)
+	}
+}
+
+func TestRelativeDates(t *testing.T) {
+	created := NewQField("created")
+	created.ParseAsDateTime()
+	qs, _ := url.ParseQuery("created=gte:now-24h,lt:now+1w")
+	before := time.Now()
+	result, err := NewQueryProcessor(created).WithFeatures(QFeatures{EnableRelativeDates: true}).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := result.Filter["created"].(bson.M)
+	start, end := filter["$gte"].(primitive.DateTime).Time(), filter["$lt"].(primitive.DateTime).Time()
+	if end.Sub(start) != 8*24*time.Hour || start.Before(before.Add(-24*time.Hour-time.Second)) || start.After(time.Now().Add(-24*time.Hour)) {
+		t.Fatalf("expected offsets from the processing time, got %v", filter)
+	}
+
+	qs, _ = url.ParseQuery("created=gt:now+1d12h")
+	result, _ = NewQueryProcessor(created).WithFeatures(QFeatures{EnableRelativeDates: true}).Process(qs)
+	if gt := result.Filter["created"].(bson.M)["$gt"].(primitive.DateTime).Time(); gt.Sub(before) < 36*time.Hour-time.Second {
+		t.Fatalf("expected + decoded as a space to add the offset, got %v", gt)
+	}
+
+	for _, qvalue := range []string{"gte:now*1d", "gte:now-1y"} {
+		if result, _ := NewQueryProcessor(created).WithFeatures(QFeatures{EnableRelativeDates: true}).Process(url.Values{"created": {qvalue}}); len(result.Filter) != 0 {
+			t.Fatalf("%s: expected the offset to be invalid, got %v", qvalue, result.Filter)
+		}
+	}
+	if result, _ := NewQueryProcessor(created).Process(url.Values{"created": {"gte:now"}}); len(result.Filter) != 0 {
+		t.Fatalf("expected relative datetimes to require the feature, got %v", result.Filter)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	count := NewQField("count")
+	count.ParseAsInt().UseDefault(func() string { return "gte:1,lte:x" })
+	empty := NewQField("empty")
+	empty.ParseAsInt().UseDefault(func() string { return "" })
+	intercepted := NewQField("intercepted")
+	intercepted.ParseAsInt().UseDefault(func() string { return "3" }).UseInterceptor(func(op string, value interface{}) (interface{}, error) {
+		return nil, errors.New("interceptors are not run")
+	})
+	created := NewQField("created")
+	created.ParseAsDateTime().UseDefault(func() string { return "gte:now-24h" })
+
+	err := NewQueryProcessor(count, empty, intercepted, created).WithFeatures(QFeatures{EnableRelativeDates: true}).Validate()
+	var errs QErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs[0], ErrValueNotValid) || !strings.Contains(errs[0].Error(), `"count"`) {
+		t.Fatalf("expected only the invalid default to be reported, got %v", err)
+	}
+	if err := NewQueryProcessor(empty, intercepted).Validate(); err != nil {
+		t.Fatalf("expected valid defaults to pass, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package mongoqs
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageSort - A sort key of a PageRequest
+type PageSort struct {
+	Key string `json:"key"` // Key of the sorted QField, or the Sort key when it is not a QField
+	Descending bool `json:"descending,omitempty"` // If true, the key is sorted in descending order
+}
+
+// PageRequest - Paging and sort information without bson types, for passing pagination to layers that do not use MongoDB, like service to service calls
+type PageRequest struct {
+	Limit int64 `json:"limit"` // Maximum number of items - unlimited when 0
+	Skip int64 `json:"skip"` // Number of items to skip
+	Sort []PageSort `json:"sort,omitempty"` // Sort keys ordered by precedence
+}
+
+// PageRequest - Returns the Limit, Skip, and Sort of the QResult as a PageRequest. Sort keys are the keys of the sorted QFields, so document paths set with UseDBKey are not exposed.
+func (r *QResult) PageRequest() PageRequest {
+	page := PageRequest{Limit: r.Limit, Skip: r.Skip, Sort: []PageSort{}}
+	for _, e := range r.Sort {
+		key := e.Key
+		if fkey, ok := r.SortFields[e.Key]; ok {
+			key = fkey
+		}
+		ord, isOrder := e.Value.(int)
+		// $meta sorts, like scores, are always descending
+		page.Sort = append(page.Sort, PageSort{Key: key, Descending: ord < 0 || !isOrder})
+	}
+	return page
+}
+
+// Query - Returns the PageRequest as lmt, skp, and srt query parameters, so it can be sent to another service that uses MongoQS.
+func (p PageRequest) Query() url.Values {
+	query := url.Values{}
+	if p.Limit > 0 {
+		query.Set(lmt, strconv.FormatInt(p.Limit, 10))
+	}
+	if p.Skip > 0 {
+		query.Set(skp, strconv.FormatInt(p.Skip, 10))
+	}
+	sorts := []string{}
+	for _, s := range p.Sort {
+		if s.Descending {
+			sorts = append(sorts, "-"+s.Key)
+		} else {
+			sorts = append(sorts, s.Key)
+		}
+	}
+	if len(sorts) > 0 {
+		query.Set(srt, strings.Join(sorts, ","))
+	}
+	return query
+}
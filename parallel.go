@@ -0,0 +1,110 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// filterJob - A field filter to build concurrently
+type filterJob struct {
+	field QField // Field to build the filter for
+	qvalue string // Query value to build the filter from
+	filter interface{} // Filter built for the field - nil if the qvalue did not produce a filter
+	clauses []QClause // Parsed clauses of the filter
+	counts QValueCounts // Number of values that could and could not be parsed
+	counted bool // If true, counts were recorded for the field
+	and []interface{} // Conditions the filter added to $and
+	warnings []QWarning // Warnings added while building the filter
+	err error // Error returned while building the filter
+}
+
+// WithParallelism - Builds field filters with up to n goroutines and merges the results in field order, so the QResult is the same as when filters are built sequentially. Goroutine overhead outweighs the gain for typical queries, so it is only worth enabling for processors with hundreds of fields that are used in the same query - compare BenchmarkProcess and BenchmarkProcessParallel on the target hardware. Interceptors must be safe for concurrent use. Returns caller for chaining.
+func (p *QProcessor) WithParallelism(n int) *QProcessor {
+	if n < 1 {
+		log.Fatal(fmt.Sprintf("Parallelism must be greater than 0 - got %d\n", n))
+	}
+	p.parallelism = n
+	return p
+}
+
+// applyFilters - Builds the filter of each job concurrently and applies them to the out QResult in job order. Jobs that fail are skipped when degrade returns true, except for panics.
+func (p *QProcessor) applyFilters(ctx context.Context, jobs []filterJob, out *QResult, degrade func(err error) bool) error {
+	workers := p.parallelism
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	var wg sync.WaitGroup
+	chunk := (len(jobs) + workers - 1) / workers
+	for start := 0; start < len(jobs); start += chunk {
+		end := start + chunk
+		if end > len(jobs) {
+			end = len(jobs)
+		}
+		wg.Add(1)
+		go func(jobs []filterJob) {
+			defer wg.Done()
+			// each worker reuses one result and moves every filter it builds to the job
+			out := QResult{Filter: bson.M{}}
+			for i := range jobs {
+				func(job *filterJob) {
+					defer func() {
+						// panics cannot be recovered by the caller's goroutine
+						if v := recover(); v != nil {
+							job.err = &QPanicError{Value: v, Stack: debug.Stack()}
+						}
+					}()
+					job.err = job.field.applyFilter(job.qvalue, &out, p.syntax)
+					job.filter = out.Filter[job.field.dbKey()]
+					job.clauses = out.Parsed[job.field.dbKey()]
+					job.counts, job.counted = out.ValueCounts[job.field.Key]
+					delete(out.ValueCounts, job.field.Key)
+					delete(out.Filter, job.field.dbKey())
+					delete(out.Parsed, job.field.dbKey())
+					out.clauseKeys = out.clauseKeys[:0]
+					job.warnings = out.Warnings
+					out.Warnings = nil
+					if and, ok := out.Filter["$and"].(bson.A); ok {
+						job.and = and
+						delete(out.Filter, "$and")
+					}
+				}(&jobs[i])
+			}
+		}(jobs[start:end])
+	}
+	wg.Wait()
+	for _, job := range jobs {
+		if job.err != nil {
+			if _, panicked := job.err.(*QPanicError); !panicked && degrade(job.err) {
+				// the field is skipped as if its filter was built sequentially
+				continue
+			}
+			return job.err
+		}
+		out.Warnings = append(out.Warnings, job.warnings...)
+		if job.counted {
+			out.ValueCounts[job.field.Key] = job.counts
+		}
+		if job.clauses == nil {
+			continue
+		}
+		if job.filter != nil {
+			out.Filter[job.field.dbKey()] = job.filter
+		}
+		for _, c := range job.and {
+			out.and(c.(bson.M))
+		}
+		out.setClauses(job.field.dbKey(), job.clauses)
+		// apply visibility conditions
+		if job.field.Visibility != nil {
+			if cond := job.field.Visibility(ctx); len(cond) > 0 {
+				out.and(cond)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package mongoqs
+
+// NewReadOnlyListProcessor - Validates the provided QFields and returns a new QProcessor configured for public list endpoints. The processor is Strict, uses a default limit of 20 and a hard cap of 100, sorts by -_id (newest first) when the query does not sort, and rejects like:, slike:, elike:, and re: for every caller, since regular expressions are the most expensive filters a client can send. Options can be changed with the processor's chainable methods.
+func NewReadOnlyListProcessor(fields ...QField) *QProcessor {
+	return NewQueryProcessor(fields...).
+		Strict().
+		WithDefaultLimit(20).
+		WithHardCap(100).
+		WithDefaultSort("-_id").
+		RestrictOperator(like).
+		RestrictOperator(slike).
+		RestrictOperator(elike).
+		RestrictOperator(re)
+}
+
+// NewAdminSearchProcessor - Validates the provided QFields and returns a new QProcessor configured for trusted back office search endpoints. The processor is Strict, uses a default limit of 50 and a hard cap of 1000, sorts by -_id (newest first) when the query does not sort, allows every operator, and allows clients to suppress Default functions with ndf. Options can be changed with the processor's chainable methods.
+func NewAdminSearchProcessor(fields ...QField) *QProcessor {
+	return NewQueryProcessor(fields...).
+		Strict().
+		WithDefaultLimit(50).
+		WithHardCap(1000).
+		WithDefaultSort("-_id").
+		AllowDefaultSuppression()
+}
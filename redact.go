@@ -0,0 +1,103 @@
+package mongoqs
+
+import (
+	"regexp"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// redacted - Replaces values of PII fields in redacted QResults
+const redacted string = "[REDACTED]"
+
+// quoted - Matches the quoted strings in warning messages, which can include values that could not be parsed
+var quoted *regexp.Regexp = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// RedactPII - Sets IsRedacted on every QResult so values of PII fields are masked when the QResult is rendered or logged. The Filter used to find documents is not changed. Returns caller for chaining.
+func (p *QProcessor) RedactPII() *QProcessor {
+	p.redact = true
+	return p
+}
+
+// Redacted - Returns a copy of the QResult for logging where the values of PII fields in the Filter, Parsed clauses, Meta, and Warnings, including values quoted in warning messages, are replaced with [REDACTED]. Operators are kept so the shape of the query is still visible. The copy has IsRedacted set to false since it has nothing left to mask.
+func (r *QResult) Redacted() QResult {
+	c := *r
+	c.IsRedacted = false
+	if len(r.pii) == 0 {
+		return c
+	}
+	c.Filter = redactFilter(r.Filter, r.pii)
+	c.Parsed = make(map[string][]QClause, len(r.Parsed))
+	for key, clauses := range r.Parsed {
+		if !r.pii[key] {
+			c.Parsed[key] = clauses
+			continue
+		}
+		masked := make([]QClause, len(clauses))
+		for i, clause := range clauses {
+			masked[i] = QClause{Op: clause.Op, Values: []string{redacted}, Value: redacted}
+		}
+		c.Parsed[key] = masked
+	}
+	c.Meta = make(map[string]string, len(r.Meta))
+	for key, v := range r.Meta {
+		if r.pii[key] {
+			v = redacted
+		}
+		c.Meta[key] = v
+	}
+	c.Warnings = make([]QWarning, len(r.Warnings))
+	for i, w := range r.Warnings {
+		if r.pii[w.Key] {
+			w.Value = redacted
+			w.Message = redactMessage(w.Message, w.Key)
+		}
+		c.Warnings[i] = w
+	}
+	return c
+}
+
+// redactFilter - Returns a copy of the filter with the values of PII paths masked, including conditions nested in $and, $or, and $nor
+func redactFilter(filter bson.M, pii map[string]bool) bson.M {
+	masked := make(bson.M, len(filter))
+	for key, v := range filter {
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			list := bson.A{}
+			for _, cond := range toList(v) {
+				if m, ok := cond.(bson.M); ok {
+					list = append(list, redactFilter(m, pii))
+				} else {
+					list = append(list, cond)
+				}
+			}
+			masked[key] = list
+		case pii[key]:
+			if ops, ok := v.(bson.M); ok {
+				maskedOps := make(bson.M, len(ops))
+				for op := range ops {
+					maskedOps[op] = redacted
+				}
+				masked[key] = maskedOps
+			} else {
+				masked[key] = redacted
+			}
+		default:
+			masked[key] = v
+		}
+	}
+	return masked
+}
+
+// redactMessage - Returns the warning message with every quoted string other than the key and operators replaced with [REDACTED]
+func redactMessage(message string, key string) string {
+	return quoted.ReplaceAllStringFunc(message, func(q string) string {
+		s, err := strconv.Unquote(q)
+		if err == nil {
+			if base, _ := negatedOp(s); s == key || isOp(base) {
+				return q
+			}
+		}
+		return strconv.Quote(redacted)
+	})
+}
@@ -0,0 +1,85 @@
+package mongoqs
+
+import (
+	"fmt"
+	"log"
+	"regexp/syntax"
+	"strings"
+)
+
+// defaultRegexMaxLength - Maximum length of re: patterns when QRegexLimits.MaxLength is 0
+const defaultRegexMaxLength int = 64
+// defaultRegexMaxQuantifiers - Maximum number of quantifiers in re: patterns when QRegexLimits.MaxQuantifiers is 0
+const defaultRegexMaxQuantifiers int = 4
+
+// QRegexLimits - Limits on the patterns clients send with re:. Patterns must also be valid RE2 syntax, which has no backreferences or lookarounds, and cannot nest quantifiers, like (a+)+, since those are the usual causes of catastrophic backtracking.
+type QRegexLimits struct {
+	MaxLength int // Maximum pattern length in bytes - 64 when 0
+	MaxQuantifiers int // Maximum number of *, +, ?, and {n,m} quantifiers - 4 when 0
+	Denied []string // Substrings patterns may not contain, like ".*" to require selective patterns
+}
+
+// AllowRegex - Allows clients to filter this QString field with re:<pattern>, which passes the pattern to $regex unescaped and case-sensitive, within the provided limits. Patterns that exceed the limits are handled by the field's failure Policy. Returns caller for chaining.
+func (f *QField) AllowRegex(limits QRegexLimits) *QField {
+	if f.Type != QString {
+		log.Fatal(fmt.Sprintf("Field %q must be parsed as a QString to allow regular expressions\n", f.Key))
+	}
+	if limits.MaxLength < 0 || limits.MaxQuantifiers < 0 {
+		log.Fatal(fmt.Sprintf("Field %q regular expression limits cannot be negative\n", f.Key))
+	}
+	f.IsRegexAllowed = true
+	f.RegexLimits = limits
+	return f
+}
+
+// checkPattern - Returns an error if the re: pattern is not allowed by the limits
+func (l QRegexLimits) checkPattern(pattern string) error {
+	max := l.MaxLength
+	if max == 0 {
+		max = defaultRegexMaxLength
+	}
+	if len(pattern) > max {
+		return fmt.Errorf("pattern is longer than %d bytes", max)
+	}
+	for _, d := range l.Denied {
+		if strings.Contains(pattern, d) {
+			return fmt.Errorf("pattern contains %q", d)
+		}
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return err
+	}
+	quantifiers, nested := countQuantifiers(re, false)
+	if nested {
+		return fmt.Errorf("pattern has nested quantifiers")
+	}
+	max = l.MaxQuantifiers
+	if max == 0 {
+		max = defaultRegexMaxQuantifiers
+	}
+	if quantifiers > max {
+		return fmt.Errorf("pattern has more than %d quantifiers", max)
+	}
+	return nil
+}
+
+// countQuantifiers - Returns the number of quantifiers in the parsed pattern and whether a quantifier is inside another quantifier
+func countQuantifiers(re *syntax.Regexp, quantified bool) (int, bool) {
+	n := 0
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if quantified {
+			return 1, true
+		}
+		n, quantified = 1, true
+	}
+	for _, sub := range re.Sub {
+		c, nested := countQuantifiers(sub, quantified)
+		if nested {
+			return n + c, true
+		}
+		n += c
+	}
+	return n, false
+}
@@ -0,0 +1,97 @@
+package mongoqs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// QReplayDiff - A QResult section that differs between two processors
+type QReplayDiff struct {
+	Section string // Name of the QResult section (Filter, Projection, Sort, Limit, Skip, or Meta)
+	From string // Section produced by the original processor as relaxed extended JSON
+	To string // Section produced by the new processor as relaxed extended JSON
+}
+
+// QReplayEntry - The result of replaying a single recorded query string
+type QReplayEntry struct {
+	Line int // Line number in the replayed input
+	Query string // Recorded query string
+	ParseError error // Error parsing the query string - the query is not processed when set
+	FromError error // Error returned by the original processor
+	ToError error // Error returned by the new processor
+	Warnings []QWarning // Warnings returned by the new processor
+	Diffs []QReplayDiff // Sections that differ between the two processors
+}
+
+// QReplayReport - The results of replaying recorded query strings through two processors
+type QReplayReport struct {
+	Queries int // Number of replayed queries
+	Entries []QReplayEntry // Entries for queries with parse errors, processor errors, warnings, or diffs
+}
+
+// Replay - Reads recorded query strings, one per line, and processes each one with both the from and to processors. Lines may be bare query strings, start with ?, or be request URIs. Blank lines and lines starting with # are skipped. The report contains an entry for each query that failed to parse, returned an error, produced warnings, or produced different results. Use the same processor for from and to to check recorded queries against a single schema.
+func Replay(r io.Reader, from, to *QProcessor) (QReplayReport, error) {
+	report := QReplayReport{Entries: []QReplayEntry{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		qstring := strings.TrimSpace(scanner.Text())
+		if qstring == "" || strings.HasPrefix(qstring, "#") {
+			continue
+		}
+		report.Queries++
+		entry := QReplayEntry{Line: line, Query: qstring}
+		if i := strings.Index(qstring, "?"); i >= 0 {
+			qstring = qstring[i+1:]
+		}
+		query, err := url.ParseQuery(qstring)
+		if err != nil {
+			entry.ParseError = err
+			report.Entries = append(report.Entries, entry)
+			continue
+		}
+		fromResult, fromErr := from.Process(query)
+		toResult, toErr := to.Process(query)
+		entry.FromError = fromErr
+		entry.ToError = toErr
+		entry.Warnings = toResult.Warnings
+		if fromErr == nil && toErr == nil {
+			entry.Diffs = diffResults(fromResult, toResult)
+		}
+		if entry.FromError != nil || entry.ToError != nil || len(entry.Warnings) > 0 || len(entry.Diffs) > 0 {
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("replay failed reading line %d: %w", line+1, err)
+	}
+	return report, nil
+}
+
+// diffResults - Returns the sections that differ between two QResults
+func diffResults(from, to QResult) []QReplayDiff {
+	diffs := []QReplayDiff{}
+	for _, section := range []struct {
+		name string
+		from interface{}
+		to interface{}
+	}{
+		{"Filter", from.Filter, to.Filter},
+		{"Projection", from.Projection, to.Projection},
+		{"Sort", from.Sort, to.Sort},
+		{"Limit", from.Limit, to.Limit},
+		{"Skip", from.Skip, to.Skip},
+		{"Meta", from.Meta, to.Meta},
+	} {
+		f := canonicalJSON(section.from)
+		t := canonicalJSON(section.to)
+		if f != t {
+			diffs = append(diffs, QReplayDiff{Section: section.name, From: f, To: t})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,66 @@
+package mongoqs
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// transientLabels - Driver error labels of errors that may succeed when retried
+var transientLabels []string = []string{"NetworkError", "RetryableReadError", "TransientTransactionError"}
+
+// QRetryPolicy - How QExecutor retries collection operations that fail with transient errors
+type QRetryPolicy struct {
+	MaxRetries int // Number of times a failed operation is retried - operations are not retried when 0
+	Timeout time.Duration // Time allowed for each attempt - attempts are only limited by the caller's context when 0
+	Backoff time.Duration // Wait before the first retry, doubled for each retry after it
+}
+
+// labeledError - Implemented by driver errors that carry error labels, like mongo.CommandError
+type labeledError interface {
+	HasErrorLabel(label string) bool
+}
+
+// isTransient - Returns true if the error has a transient driver error label or the attempt timed out
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var labeled labeledError
+	if errors.As(err, &labeled) {
+		for _, label := range transientLabels {
+			if labeled.HasErrorLabel(label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithRetryPolicy - Sets how Find, Count, and List retry collection operations that fail with transient errors - errors labeled NetworkError, RetryableReadError, or TransientTransactionError by the driver, and attempts that exceed the policy Timeout. Operations are never retried once the caller's context is done. Operations in a transaction should not be retried on their own, so use the zero policy for executors that run in transactions. Returns caller for chaining.
+func (e *QExecutor) WithRetryPolicy(policy QRetryPolicy) *QExecutor {
+	e.retry = policy
+	return e
+}
+
+// attempt - Runs the operation with the executor's retry policy
+func (e *QExecutor) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	backoff := e.retry.Backoff
+	for retries := 0; ; retries++ {
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if e.retry.Timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, e.retry.Timeout)
+		}
+		err := op(actx)
+		cancel()
+		if err == nil || retries >= e.retry.MaxRetries || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
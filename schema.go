@@ -0,0 +1,155 @@
+package mongoqs
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonObject - A JSON object in a generated schema
+type jsonObject map[string]interface{}
+
+// schemaValues - Map of QTypes to patterns matching a single value that the type can parse
+var schemaValues map[QType]string = map[QType]string{
+	QString: `[^,]*`,
+	QInt: `[-+]?\d+`,
+	QFloat: `[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`,
+	QDecimal: `[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`,
+	QBool: `(?:1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)`,
+	QDateTime: `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[-+]\d{2}:\d{2})`,
+	QObjectID: `[0-9a-fA-F]{24}`,
+	QIP: `[0-9a-fA-F:.]+(?:/\d{1,3})?`,
+	QSemver: `v?\d+(?:\.\d+){0,2}(?:-[0-9A-Za-z.-]+)?`,
+	QTimestamp: `\d+`,
+	QCustom: `[^,]*`,
+	QGeo: `(?:[-+]?[\d.]+|(?:poly|box|circle)\([-+\d.,]+\))`,
+}
+
+// schemaPattern - Returns a pattern matching every qvalue the field can parse into a filter
+func (p *QProcessor) schemaPattern(f QField) string {
+	types := f.Coercion
+	if len(types) == 0 {
+		types = []QType{f.Type}
+	}
+	values := []string{}
+	for _, t := range types {
+		if t == QDateTime && len(f.TimeLayouts) > 0 {
+			// custom layouts can take any form, and layouts with commas, like time.RFC1123, span values
+			values = append(values, schemaValues[QString])
+			continue
+		}
+		values = append(values, schemaValues[t])
+	}
+	if p.features.EnableRelativeDates && f.Type == QDateTime {
+		values = append(values, regexp.QuoteMeta(relnow)+`(?:[-+ ][\w.]+)?`)
+	}
+	if p.features.EnableKeywords {
+		values = append(values, "null")
+	}
+	if len(p.lists) > 0 {
+		values = append(values, regexp.QuoteMeta(listref)+`\w+`)
+	}
+	value := "(?:" + strings.Join(values, "|") + ")"
+	typed := []string{}
+	clauses := []string{}
+	for _, op := range fieldOps(f, p.syntax) {
+		switch op {
+		case exists:
+			clauses = append(clauses, regexp.QuoteMeta(op)+schemaValues[QBool])
+		case emptyarray:
+			clauses = append(clauses, regexp.QuoteMeta(op))
+		case re:
+			// patterns may contain commas and operators, so the rest of the qvalue is the pattern
+			clauses = append(clauses, regexp.QuoteMeta(op)+".*")
+		default:
+			typed = append(typed, regexp.QuoteMeta(op))
+		}
+	}
+	clauses = append(clauses, "(?:(?:"+strings.Join(typed, "|")+"))?"+value)
+	clause := "(?:" + strings.Join(clauses, "|") + ")"
+	return "^" + clause + "(?:," + clause + ")*$"
+}
+
+// schemaKeys - Returns a pattern matching a comma separated list of the provided keys, each with an optional prefix and suffix
+func schemaKeys(keys []string, prefix string, suffix string) string {
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	sort.Strings(quoted)
+	entry := prefix + "(?:" + strings.Join(quoted, "|") + ")" + suffix
+	return "^" + entry + "(?:," + entry + ")*$"
+}
+
+// JSONSchema - Returns a JSON Schema (draft-07) describing the query parameters accepted by the processor, for validating requests at an API gateway before they reach the service. Every parameter is described as a string with a pattern matching the values the processor can parse. Undeclared parameters are allowed since they are ignored by the processor.
+func (p *QProcessor) JSONSchema() ([]byte, error) {
+	properties := map[string]interface{}{
+		lmt: jsonObject{"type": "string", "pattern": `^[1-9]\d*$`},
+		skp: jsonObject{"type": "string", "pattern": `^\d+$`},
+	}
+	if p.unlimitedCap > 0 {
+		properties[unl] = jsonObject{"type": "string", "pattern": "^" + schemaValues[QBool] + "$"}
+	}
+	sortKeys := append([]string{}, p.extraSortKeys...)
+	projectionKeys := append([]string{}, p.extraProjectionKeys...)
+	for _, f := range p.fields {
+		keys := append([]string{f.Key}, f.Aliases...)
+		if f.IsSortable {
+			sortKeys = append(sortKeys, keys...)
+		}
+		if f.IsProjectable {
+			projectionKeys = append(projectionKeys, keys...)
+		}
+		if f.IsNotFilterable {
+			continue
+		}
+		schema := jsonObject{"type": "string"}
+		if !f.IsMeta {
+			schema["pattern"] = p.schemaPattern(f)
+		}
+		for _, k := range keys {
+			properties[k] = schema
+		}
+	}
+	for name := range p.sortPresets {
+		sortKeys = append(sortKeys, name)
+	}
+	for name := range p.computed {
+		projectionKeys = append(projectionKeys, name)
+	}
+	for name := range p.virtual {
+		sortKeys = append(sortKeys, name)
+		projectionKeys = append(projectionKeys, name)
+	}
+	prefix := `[-+]?`
+	if p.syntax >= SyntaxV2 {
+		prefix = `[-+ ]?`
+	}
+	if len(sortKeys) > 0 {
+		properties[srt] = jsonObject{"type": "string", "pattern": schemaKeys(sortKeys, prefix, "(?::asc|:desc)?")}
+	}
+	if len(projectionKeys) > 0 {
+		properties[prj] = jsonObject{"type": "string", "pattern": schemaKeys(projectionKeys, prefix, "")}
+	}
+	if p.IsDefaultSuppressible {
+		properties[ndf] = jsonObject{"type": "string"}
+	}
+	if len(p.templates) > 0 {
+		names := []string{}
+		for name := range p.templates {
+			names = append(names, name)
+		}
+		for i, name := range names {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		sort.Strings(names)
+		properties[tpl] = jsonObject{"type": "string", "pattern": "^(?:" + strings.Join(names, "|") + ")(?::.*)?$"}
+	}
+	return json.MarshalIndent(jsonObject{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"properties": properties,
+	}, "", "  ")
+}
+
@@ -0,0 +1,61 @@
+package mongoqs
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// highlights - Projection key of Atlas Search highlights
+const highlights string = "highlights"
+
+// qsearch - Atlas Search options
+type qsearch struct {
+	index string // Name of the search index
+	paths []string // Document paths searched by sch text
+}
+
+// WithAtlasSearch - Allows clients to send sch=<text> to run an Atlas Search text query on the provided paths of the index, and hlt=true to request highlights of the matched text. The QResult Search stage is built from the text, and the QResult Filter is applied after it with $match. Returns caller for chaining.
+func (p *QProcessor) WithAtlasSearch(index string, paths ...string) *QProcessor {
+	if index == "" || len(paths) == 0 {
+		log.Fatal("Atlas Search index cannot be an empty string and at least one path is required")
+	}
+	p.search = &qsearch{index: index, paths: paths}
+	return p
+}
+
+// applySearch - Builds the $search stage for the sch text and the highlight projection for the hlt value
+func (p *QProcessor) applySearch(text string, qhlt string, out *QResult) error {
+	if p.search == nil {
+		out.warn(sch, text, "search ignored - Atlas Search is not enabled")
+		return nil
+	}
+	if out.VectorSearch != nil {
+		return fmt.Errorf("%s and %s cannot be used in the same query", sch, vec)
+	}
+	out.Search = bson.M{
+		"index": p.search.index,
+		"text": bson.M{"query": text, "path": p.search.paths},
+	}
+	if qhlt == "" {
+		return nil
+	}
+	if h, err := strconv.ParseBool(qhlt); err != nil {
+		out.warn(hlt, qhlt, fmt.Sprintf("highlights ignored - %v", err))
+	} else if h {
+		out.Search["highlight"] = bson.M{"path": p.search.paths}
+		meta := bson.M{"$meta": "searchHighlights"}
+		for key, v := range out.Projection {
+			if key != "_id" && v == 1 {
+				// inclusion projections must include the highlights to return them
+				out.Projection[highlights] = meta
+				return nil
+			}
+		}
+		// a $project with only the highlights would drop every other field, and computed values cannot be mixed with exclusions
+		out.AddFields[highlights] = meta
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package mongoqs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QSemverStorage - How a QSemver field's versions are stored in documents
+type QSemverStorage int
+// SemverKey - Versions are stored as the sortable string returned by QVersion.Key, so every operator compares a single value. QSemver fields use this storage by default.
+const SemverKey QSemverStorage = 0
+// SemverFields - Versions are stored as an embedded document with major, minor, and patch integer fields, like {"version": {"major": 1, "minor": 2, "patch": 3}}. Pre-release versions cannot be stored in this layout.
+const SemverFields QSemverStorage = 1
+
+// semverWidth - Number of digits each version number is padded to in a sortable key
+const semverWidth int = 10
+
+// QVersion - A parsed semantic version
+type QVersion struct {
+	Major int64
+	Minor int64
+	Patch int64
+	Prerelease string // Pre-release identifiers, like alpha.1 - empty for releases
+}
+
+// ParseVersion - Parses a semantic version, like 1.2.3, v1.2.3, or 1.2.3-beta.1. Missing minor and patch numbers are 0 and build metadata is ignored.
+func ParseVersion(v string) (QVersion, error) {
+	version := QVersion{}
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(s, "+ "); i >= 0 {
+		// build metadata does not affect precedence - form encoding decodes + as a space
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		version.Prerelease = s[i+1:]
+		s = s[:i]
+		if version.Prerelease == "" {
+			return QVersion{}, fmt.Errorf("%q has an empty pre-release", v)
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return QVersion{}, fmt.Errorf("%q has more than 3 version numbers", v)
+	}
+	numbers := []*int64{&version.Major, &version.Minor, &version.Patch}
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 || len(part) > semverWidth {
+			return QVersion{}, fmt.Errorf("%q is not a semantic version", v)
+		}
+		*numbers[i] = n
+	}
+	return version, nil
+}
+
+// Key - Returns a string that sorts in version precedence order, for storing versions with SemverKey storage. Releases sort after their pre-releases, and pre-releases are compared as strings.
+func (v QVersion) Key() string {
+	key := fmt.Sprintf("%0*d.%0*d.%0*d", semverWidth, v.Major, semverWidth, v.Minor, semverWidth, v.Patch)
+	if v.Prerelease != "" {
+		return key + "-" + v.Prerelease
+	}
+	// ~ sorts after - so releases follow their pre-releases
+	return key + "~"
+}
+
+// String - Returns the version as major.minor.patch with its pre-release
+func (v QVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// parseSemverValue - Parses a single version in the field's storage form
+func (f *QField) parseSemverValue(v string) (interface{}, error) {
+	version, err := ParseVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	if f.SemverStorage == SemverFields {
+		if version.Prerelease != "" {
+			return nil, fmt.Errorf("%q is a pre-release - pre-releases cannot be stored as fields", v)
+		}
+		return version, nil
+	}
+	return version.Key(), nil
+}
+
+// semverCondition - Returns the condition comparing the major, minor, and patch fields under the key with the version
+func semverCondition(key string, op string, v QVersion) bson.M {
+	major, minor, patch := key+".major", key+".minor", key+".patch"
+	equal := bson.M{major: v.Major, minor: v.Minor, patch: v.Patch}
+	switch op {
+	case ne:
+		return bson.M{"$nor": bson.A{equal}}
+	case gt, gte, lt, lte:
+		// compare the first version number that differs
+		strict := "$gt"
+		if op == lt || op == lte {
+			strict = "$lt"
+		}
+		return bson.M{"$or": bson.A{
+			bson.M{major: bson.M{strict: v.Major}},
+			bson.M{major: v.Major, minor: bson.M{strict: v.Minor}},
+			bson.M{major: v.Major, minor: v.Minor, patch: bson.M{toMOp(op): v.Patch}},
+		}}
+	}
+	return equal
+}
+
+// semverListCondition - Returns the condition matching any, or none, of the versions for in: and nin:
+func semverListCondition(key string, op string, versions []QVersion) bson.M {
+	list := bson.A{}
+	for _, v := range versions {
+		list = append(list, semverCondition(key, eq, v))
+	}
+	if op == nin {
+		return bson.M{"$nor": list}
+	}
+	return bson.M{"$or": list}
+}
+
+// semverParser - The qvalueParser of QSemver fields. Lists are a []QVersion for SemverFields storage and a []string of keys for SemverKey storage.
+type semverParser struct{}
+
+// value - Returns the version, or its key, parsed from v
+func (semverParser) value(f *QField, v string) (interface{}, error) {
+	return f.parseSemverValue(v)
+}
+
+// list - Returns the versions, or their keys, that could be parsed, or nil if none could be parsed
+func (semverParser) list(f *QField, op string, values []string, invalid func(op string, v string)) interface{} {
+	if f.SemverStorage == SemverFields {
+		if op == all {
+			// a version stored as fields is a single value
+			invalid(op, strings.Join(values, ","))
+			return nil
+		}
+		versions := []QVersion{}
+		for _, v := range values {
+			if version, err := f.parseSemverValue(v); err == nil {
+				versions = append(versions, version.(QVersion))
+			} else {
+				invalid(op, v)
+			}
+		}
+		if len(versions) > 0 {
+			return versions
+		}
+		return nil
+	}
+	keys := []string{}
+	for _, v := range values {
+		if key, err := f.parseSemverValue(v); err == nil {
+			keys = append(keys, key.(string))
+		} else {
+			invalid(op, v)
+		}
+	}
+	if len(keys) > 0 {
+		return keys
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package mongoqs
+
+import (
+	"fmt"
+	"log"
+)
+
+// WithShardKey - Declares the keys of the fields in the collection's shard key, in shard key order. A warning is added to the QResult, and counted in the usage statistics when usage is tracked, when a query does not filter each of the fields with eq: or in:, since mongos sends those queries to every shard. Declare only a prefix of the shard key if queries are expected to filter by the prefix. Exits if a key is not the Key of one of the processor's fields. Returns caller for chaining.
+func (p *QProcessor) WithShardKey(keys ...string) *QProcessor {
+	for _, key := range keys {
+		if p.fieldByKey(key) == nil {
+			log.Fatal(fmt.Sprintf("Shard key field %q is not a field of the processor\n", key))
+		}
+	}
+	p.shardKey = append([]string{}, keys...)
+	return p
+}
+
+// checkShardKey - Adds a warning for each shard key field without equality and returns true if the query is sent to every shard
+func (p *QProcessor) checkShardKey(out *QResult) bool {
+	scatter := false
+	for _, key := range p.shardKey {
+		field := p.fieldByKey(key)
+		equality := false
+		for _, c := range out.Parsed[field.dbKey()] {
+			equality = equality || c.Op == eq || c.Op == in
+		}
+		if !equality {
+			out.warn(field.Key, "", "query has no eq: or in: filter on the shard key field - it is sent to every shard")
+			scatter = true
+		}
+	}
+	return scatter
+}
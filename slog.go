@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package mongoqs
+
+import (
+	"log/slog"
+)
+
+// LogValue - Implements slog.LogValuer so a *QResult is logged as a group of the filter, projection, and sort as JSON, the paging, and the warnings when there are any, with the values of PII fields masked when IsRedacted is set, like slog.Any("query", &result).
+func (r *QResult) LogValue() slog.Value {
+	if r.IsRedacted {
+		c := r.Redacted()
+		r = &c
+	}
+	attrs := []slog.Attr{
+		slog.String("filter", canonicalJSON(r.Filter)),
+		slog.String("projection", canonicalJSON(r.Projection)),
+		slog.String("sort", canonicalJSON(r.Sort)),
+		slog.Group("paging", slog.Int64("limit", r.Limit), slog.Int64("skip", r.Skip)),
+	}
+	if len(r.Warnings) > 0 {
+		warnings := make([]string, len(r.Warnings))
+		for i, w := range r.Warnings {
+			warnings[i] = w.String()
+		}
+		attrs = append(attrs, slog.Any("warnings", warnings))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package mongoqs
+
+import (
+	"bytes"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLogValue(t *testing.T) {
+	count := NewQField("count")
+	count.ParseAsInt()
+	qs, _ := url.ParseQuery("count=gt:1&lmt=5&skp=-1")
+	result, _ := NewQueryProcessor(count).Process(qs)
+
+	var b bytes.Buffer
+	slog.New(slog.NewTextHandler(&b, nil)).Info("query", "q", &result)
+	out := b.String()
+	for _, expected := range []string{`q.filter="{\"count\":{\"$gt\":1}}"`, "q.paging.limit=5", "q.paging.skip=0", "q.warnings="} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected %s in %s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mongoqs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// qtimeseries - Time series collection options
+type qtimeseries struct {
+	time string // Key of the field holding the collection's timeField
+	meta string // Key of the field holding the collection's metaField - empty when the collection has no metaField
+}
+
+// WithTimeSeries - Declares the keys of the fields holding the timeField and metaField of a time series collection. The time field must be a QDateTime field and the meta field may be an empty string when the collection has no metaField. Queries are still processed normally, but a warning is added when they cannot use the collection's buckets: when the time field has no range, when the meta field is filtered with anything other than eq: or in:, and when the first sort is not on the meta or time field. Returns caller for chaining.
+func (p *QProcessor) WithTimeSeries(timeField string, metaField string) *QProcessor {
+	f := p.fieldByKey(timeField)
+	if f == nil {
+		log.Fatal(fmt.Sprintf("Time series time field %q is not a field of the processor\n", timeField))
+	}
+	if f.Type != QDateTime {
+		log.Fatal(fmt.Sprintf("Time series time field %q must be a QDateTime field\n", timeField))
+	}
+	if metaField != "" && p.fieldByKey(metaField) == nil {
+		log.Fatal(fmt.Sprintf("Time series meta field %q is not a field of the processor\n", metaField))
+	}
+	p.timeseries = &qtimeseries{time: timeField, meta: metaField}
+	return p
+}
+
+// checkTimeSeries - Adds warnings for the parts of the QResult that cannot use the time series buckets
+func (p *QProcessor) checkTimeSeries(out *QResult) {
+	// fields are resolved by key so a derived processor checks its own overrides
+	time, meta := p.fieldByKey(p.timeseries.time), p.fieldByKey(p.timeseries.meta)
+	ranged := false
+	for _, c := range out.Parsed[time.dbKey()] {
+		switch c.Op {
+		case eq, gt, gte, lt, lte:
+			ranged = true
+		}
+	}
+	if !ranged {
+		out.warn(time.Key, "", "time series query has no range on the time field - every bucket is scanned")
+	}
+	if meta != nil {
+		for _, c := range out.Parsed[meta.dbKey()] {
+			if c.Op != eq && c.Op != in {
+				out.warn(meta.Key, c.Op+strings.Join(c.Values, ","), "only eq: and in: on the meta field can use the time series buckets")
+			}
+		}
+	}
+	if len(out.Sort) > 0 {
+		key := out.Sort[0].Key
+		if key != time.dbKey() && (meta == nil || (key != meta.dbKey() && !strings.HasPrefix(key, meta.dbKey()+"."))) {
+			out.warn(srt, out.SortInputs[key], "sorts that do not start with the meta or time field cannot use the time series buckets")
+		}
+	}
+}
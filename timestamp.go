@@ -0,0 +1,30 @@
+package mongoqs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// parseTimestamp - Parses a QTimestamp value - seconds since the Unix epoch, optionally followed by a , and the increment. The increment is 0 when it is not provided.
+func parseTimestamp(v string) (primitive.Timestamp, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) > 2 {
+		return primitive.Timestamp{}, fmt.Errorf("%q is not a timestamp - expected seconds or seconds,increment", v)
+	}
+	t, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("%q is not a timestamp - expected seconds or seconds,increment", v)
+	}
+	ts := primitive.Timestamp{T: uint32(t)}
+	if len(parts) == 2 {
+		i, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return primitive.Timestamp{}, fmt.Errorf("%q is not a timestamp - expected seconds or seconds,increment", v)
+		}
+		ts.I = uint32(i)
+	}
+	return ts, nil
+}
@@ -0,0 +1,62 @@
+package mongoqs
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QTypedField - A QCustom QField whose values are parsed as T by the same typed parser used for the built-in QTypes, so parsed clauses have compile-time typed values and list operators have a []T. The embedded QField is passed to NewQueryProcessor and configured with the usual chainable methods.
+type QTypedField[T any] struct {
+	QField
+}
+
+// QTypedClause - A parsed clause of a QTypedField
+type QTypedClause[T any] struct {
+	Op string // Operator, including the trailing :
+	Values []T // Values parsed as T - one for comparison operators and every member for list operators. Empty for operators whose value is not parsed as T, like exists: and emptyarray:.
+	Clause QClause // The untyped clause the values were taken from
+}
+
+// NewTypedQField - Returns a new QTypedField with the provided key that parses each value with the provided function. The function is also set as the field's Parser so the field can be used wherever a QCustom field can.
+func NewTypedQField[T any](key string, parse func(raw string) (T, error)) *QTypedField[T] {
+	f := &QTypedField[T]{QField: NewQField(key)}
+	f.UseParser(func(raw string) (interface{}, error) {
+		v, err := parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	f.typed = parserOf(parse)
+	return f
+}
+
+// Parse - Parses the qvalue like QField.Parse and returns the clauses with their values as T. Values replaced by the field's interceptor with a value that is not a T are left out.
+func (f *QTypedField[T]) Parse(qvalue string) ([]QTypedClause[T], error) {
+	clauses, err := f.QField.Parse(qvalue)
+	if err != nil {
+		return nil, err
+	}
+	typed := make([]QTypedClause[T], len(clauses))
+	for i, c := range clauses {
+		typed[i] = QTypedClause[T]{Op: c.Op, Values: typedValues[T](c.Value), Clause: c}
+	}
+	return typed, nil
+}
+
+// typedValues - Returns the value, or the members of a list value, that are a T
+func typedValues[T any](value interface{}) []T {
+	values := []T{}
+	switch list := value.(type) {
+	case T:
+		return append(values, list)
+	case []T:
+		return append(values, list...)
+	case bson.A:
+		for _, member := range list {
+			if v, ok := member.(T); ok {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,37 @@
+package mongoqs
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestTypedField(t *testing.T) {
+	type level int32
+	priority := NewTypedQField("priority", func(raw string) (level, error) {
+		n, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || n < 0 || n > 5 {
+			return 0, fmt.Errorf("%q is not a priority", raw)
+		}
+		return level(n), nil
+	})
+	priority.Sortable()
+
+	clauses, err := priority.Parse("gte:2,in:1,3,9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clauses) != 2 || clauses[0].Values[0] != 2 || fmt.Sprint(clauses[1].Values) != "[1 3]" {
+		t.Fatalf("expected typed values, got %v", clauses)
+	}
+
+	qs, _ := url.ParseQuery("priority=lt:4&srt=-priority")
+	result, err := NewQueryProcessor(priority.QField).Process(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Filter["priority"] == nil || len(result.Sort) != 1 {
+		t.Fatalf("expected the typed field to filter and sort, got %v %v", result.Filter, result.Sort)
+	}
+}
@@ -0,0 +1,85 @@
+package mongoqs
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// qvalueParser - Parses the values of a field, as a single value or as the members of a list operator
+type qvalueParser interface {
+	value(f *QField, v string) (interface{}, error) // Returns the value parsed from v
+	list(f *QField, op string, values []string, invalid func(op string, v string)) interface{} // Returns a slice of the values that could be parsed, recording the others with invalid, or nil if none could be parsed
+}
+
+// typedParser - A qvalueParser that parses values as T, so list operators have a []T
+type typedParser[T any] struct {
+	parse func(f *QField, v string) (T, error)
+}
+
+// value - Returns the value parsed as T
+func (t typedParser[T]) value(f *QField, v string) (interface{}, error) {
+	return t.parse(f, v)
+}
+
+// list - Returns a []T of the values that could be parsed, or nil if none could be parsed
+func (t typedParser[T]) list(f *QField, op string, values []string, invalid func(op string, v string)) interface{} {
+	list := []T{}
+	for _, v := range values {
+		if x, err := t.parse(f, v); err == nil {
+			list = append(list, x)
+		} else {
+			invalid(op, v)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// parserOf - Returns a typedParser for a parse function that does not depend on the field
+func parserOf[T any](parse func(v string) (T, error)) typedParser[T] {
+	return typedParser[T]{parse: func(f *QField, v string) (T, error) {
+		return parse(v)
+	}}
+}
+
+// qtypes - Map of QTypes to the parsers of their values. Operators of QIP fields are built by ipClauses unless the field has a coercion chain, and QGeo values are only parsed by near: and within:.
+var qtypes map[QType]qvalueParser = map[QType]qvalueParser{
+	QString: parserOf(func(v string) (string, error) { return v, nil }),
+	QInt: parserOf(func(v string) (int64, error) { return strconv.ParseInt(v, 10, 64) }),
+	QFloat: parserOf(func(v string) (float64, error) { return strconv.ParseFloat(v, 64) }),
+	QBool: parserOf(strconv.ParseBool),
+	QDateTime: typedParser[primitive.DateTime]{parse: func(f *QField, v string) (primitive.DateTime, error) {
+		d, err := f.parseTime(v)
+		if err != nil {
+			return 0, err
+		}
+		return primitive.NewDateTimeFromTime(d), nil
+	}},
+	QObjectID: parserOf(primitive.ObjectIDFromHex),
+	QDecimal: parserOf(primitive.ParseDecimal128),
+	QTimestamp: parserOf(parseTimestamp),
+	QIP: typedParser[interface{}]{parse: func(f *QField, v string) (interface{}, error) { return f.parseIPValue(v) }},
+	QSemver: semverParser{},
+	QGeo: typedParser[interface{}]{parse: func(f *QField, v string) (interface{}, error) {
+		return nil, fmt.Errorf("%q must be used with %s or %s", v, near, within)
+	}},
+	QCustom: typedParser[interface{}]{parse: func(f *QField, v string) (interface{}, error) {
+		if f.Parser == nil {
+			return nil, fmt.Errorf("field %q has no parser", f.Key)
+		}
+		return f.Parser(v)
+	}},
+}
+
+// valueParser - Returns the parser of the field's values - the typed parser of a QTypedField, or the parser of the field's Type
+func (f *QField) valueParser() (qvalueParser, bool) {
+	if f.typed != nil {
+		return f.typed, true
+	}
+	p, ok := qtypes[f.Type]
+	return p, ok
+}
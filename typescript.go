@@ -0,0 +1,145 @@
+package mongoqs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tsTypes - Map of QTypes to TypeScript value types
+var tsTypes map[QType]string = map[QType]string{QString: "string", QInt: "number", QFloat: "number", QBool: "boolean", QDateTime: "Date | string", QObjectID: "string", QIP: "string", QSemver: "string", QGeo: "string", QDecimal: "string", QTimestamp: "number"}
+
+// tsKey - Returns the operator without the trailing : as a TypeScript property name, quoted when it is not an identifier, like "not:like"
+func tsKey(op string) string {
+	key := strings.TrimSuffix(op, ":")
+	if strings.ContainsAny(key, ":!") {
+		return strconv.Quote(key)
+	}
+	return key
+}
+
+// tsUnion - Returns a TypeScript union of quoted strings, or never if there are none
+func tsUnion(values []string) string {
+	if len(values) == 0 {
+		return "never"
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return strings.Join(quoted, " | ")
+}
+
+// TypeScript - Returns TypeScript type definitions and a query string builder matching the processor's fields and operators. Exported names are prefixed with the provided name, so TypeScript("Item") exports ItemFilter, ItemQuery, buildItemQuery, and so on.
+func (p *QProcessor) TypeScript(name string) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by mongoqs. DO NOT EDIT.\n\n")
+
+	sortKeys := append([]string{}, p.extraSortKeys...)
+	projectionKeys := append([]string{}, p.extraProjectionKeys...)
+	metaKeys := []string{}
+	fmt.Fprintf(&b, "export interface %sFilter {\n", name)
+	for _, f := range p.fields {
+		if f.IsSortable {
+			sortKeys = append(sortKeys, append([]string{f.Key}, f.Aliases...)...)
+		}
+		if f.IsProjectable && !f.IsPII {
+			projectionKeys = append(projectionKeys, append([]string{f.Key}, f.Aliases...)...)
+		}
+		if f.IsMeta {
+			metaKeys = append(metaKeys, f.Key)
+			continue
+		}
+		if f.IsNotFilterable {
+			continue
+		}
+		t := tsTypes[f.Type]
+		if t == "" {
+			t = "string"
+		}
+		fmt.Fprintf(&b, "  %s?: {\n", strconv.Quote(f.Key))
+		for _, op := range fieldOps(f, p.syntax) {
+			base, _ := negatedOp(op)
+			switch {
+			case base == exists:
+				fmt.Fprintf(&b, "    %s?: boolean;\n", tsKey(op))
+			case base == emptyarray:
+				fmt.Fprintf(&b, "    %s?: [];\n", tsKey(op))
+			case base == between:
+				fmt.Fprintf(&b, "    %[1]s?: [%[2]s, %[2]s];\n", tsKey(op), t)
+			case base == re:
+				fmt.Fprintf(&b, "    %s?: string;\n", tsKey(op))
+			case isListOp(op):
+				fmt.Fprintf(&b, "    %s?: (%s)[];\n", tsKey(op), t)
+			case f.Type == QTimestamp:
+				// comparisons take the seconds and an optional increment
+				fmt.Fprintf(&b, "    %s?: number | [number, number];\n", tsKey(op))
+			default:
+				fmt.Fprintf(&b, "    %s?: %s;\n", tsKey(op), t)
+			}
+		}
+		b.WriteString("  };\n")
+	}
+	b.WriteString("}\n\n")
+	for name := range p.sortPresets {
+		sortKeys = append(sortKeys, name)
+	}
+	for name := range p.computed {
+		projectionKeys = append(projectionKeys, name)
+	}
+	for name := range p.virtual {
+		sortKeys = append(sortKeys, name)
+		projectionKeys = append(projectionKeys, name)
+	}
+	fmt.Fprintf(&b, "export type %sSortKey = %s;\n\n", name, tsUnion(sortKeys))
+	fmt.Fprintf(&b, "export type %sProjectionKey = %s;\n\n", name, tsUnion(projectionKeys))
+	fmt.Fprintf(&b, "export type %sMetaKey = %s;\n\n", name, tsUnion(metaKeys))
+	fmt.Fprintf(&b, "export interface %sQuery {\n", name)
+	fmt.Fprintf(&b, "  filter?: %sFilter;\n", name)
+	fmt.Fprintf(&b, "  sort?: (%[1]sSortKey | `-${%[1]sSortKey}` | `+${%[1]sSortKey}`)[];\n", name)
+	fmt.Fprintf(&b, "  projection?: (%[1]sProjectionKey | `-${%[1]sProjectionKey}` | `+${%[1]sProjectionKey}`)[];\n", name)
+	b.WriteString("  limit?: number;\n")
+	b.WriteString("  skip?: number;\n")
+	fmt.Fprintf(&b, "  meta?: Partial<Record<%sMetaKey, string>>;\n", name)
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, `function format%[1]sValue(value: unknown): string {
+  return value instanceof Date ? value.toISOString() : String(value);
+}
+
+export function build%[1]sQuery(query: %[1]sQuery): string {
+  const params = new URLSearchParams();
+  for (const [key, ops] of Object.entries(query.filter ?? {})) {
+    const clauses: string[] = [];
+    for (const [op, value] of Object.entries(ops ?? {})) {
+      if (value === undefined) {
+        continue;
+      }
+      const values = Array.isArray(value) ? value : [value];
+      clauses.push(op + ":" + values.map(format%[1]sValue).join(","));
+    }
+    if (clauses.length > 0) {
+      params.append(key, clauses.join(","));
+    }
+  }
+  if (query.sort && query.sort.length > 0) {
+    params.set(%[2]s, query.sort.join(","));
+  }
+  if (query.projection && query.projection.length > 0) {
+    params.set(%[3]s, query.projection.join(","));
+  }
+  if (query.limit !== undefined) {
+    params.set(%[4]s, String(query.limit));
+  }
+  if (query.skip !== undefined) {
+    params.set(%[5]s, String(query.skip));
+  }
+  for (const [key, value] of Object.entries(query.meta ?? {})) {
+    if (value !== undefined) {
+      params.set(key, String(value));
+    }
+  }
+  return params.toString();
+}
+`, name, strconv.Quote(srt), strconv.Quote(prj), strconv.Quote(lmt), strconv.Quote(skp))
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package mongoqs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QUsage - Snapshot of how often fields, aliases, operators, and sorts were used in processed queries. Helps maintainers decide which aliases can be retired and which fields need indexes.
+type QUsage struct {
+	Queries int64 // Number of processed queries
+	Fields map[string]int64 // Map of field keys to the number of queries that filtered on the field, whether by key or alias
+	Aliases map[string]int64 // Map of aliases to the number of queries that used the alias instead of the field key in a filter, sort, or projection
+	Operators map[string]int64 // Map of operators to the number of times they were used
+	Sorts map[string]int64 // Map of sort keys to the number of queries that sorted by the key
+	ScatterGather int64 // Number of queries without equality on the processor's shard key
+}
+
+// usedField - A field that was supplied by a query
+type usedField struct {
+	key string // Field key
+	dbkey string // Document path of the field
+	source string // Query parameter that supplied the value - the field key or an alias
+	qvalue string // Raw query value
+	t QType // Field type
+	syntax QSyntax // Syntax version used to parse the value
+}
+
+// usageTracker - Collects QUsage across concurrent requests
+type usageTracker struct {
+	mu sync.Mutex
+	usage QUsage
+	shapes map[string]*QIndexCandidate // Map of query shapes to index candidates
+	fieldOps map[string]map[string]int64 // Map of field keys to operator counts
+}
+
+// newQUsage - Returns a new empty QUsage
+func newQUsage() QUsage {
+	return QUsage{
+		Fields: make(map[string]int64),
+		Aliases: make(map[string]int64),
+		Operators: make(map[string]int64),
+		Sorts: make(map[string]int64),
+	}
+}
+
+// record - Adds the fields, the aliases used in sorts and projections, and the sorts of a single query, and whether it was sent to every shard, to the usage statistics
+func (t *usageTracker) record(used []usedField, aliases []string, sorts bson.D, scatter bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage.Queries++
+	if scatter {
+		t.usage.ScatterGather++
+	}
+	// each alias is counted once per query, however many parameters used it
+	counted := make(map[string]bool)
+	for _, a := range aliases {
+		counted[a] = true
+	}
+	equality := []string{}
+	ranges := []string{}
+	geos := []string{}
+	for _, u := range used {
+		t.usage.Fields[u.key]++
+		if u.source != u.key {
+			counted[u.source] = true
+		}
+		if t.fieldOps[u.key] == nil {
+			t.fieldOps[u.key] = make(map[string]int64)
+		}
+		isEquality := false
+		isRange := false
+		isGeo := false
+		for op := range toOpValueMap(u.qvalue, u.t, u.syntax) {
+			t.usage.Operators[op]++
+			t.fieldOps[u.key][op]++
+			switch op {
+			case eq, in, all:
+				isEquality = true
+			case ne, nin, gt, gte, lt, lte, between, slike:
+				isRange = true
+			case near, within:
+				isGeo = true
+			default:
+				if _, ok := negatedOp(op); ok {
+					// negations match most documents like ne: and nin:
+					isRange = true
+				}
+			}
+		}
+		if isGeo {
+			geos = append(geos, u.dbkey)
+		} else if isEquality {
+			equality = append(equality, u.dbkey)
+		} else if isRange {
+			ranges = append(ranges, u.dbkey)
+		}
+	}
+	for a := range counted {
+		t.usage.Aliases[a]++
+	}
+	for _, s := range sorts {
+		t.usage.Sorts[s.Key]++
+	}
+	// index keys follow the equality, sort, range rule
+	sort.Strings(equality)
+	sort.Strings(ranges)
+	sort.Strings(geos)
+	keys := bson.D{}
+	seen := make(map[string]bool)
+	for _, key := range equality {
+		keys = append(keys, bson.E{Key: key, Value: 1})
+		seen[key] = true
+	}
+	for _, s := range sorts {
+		if _, isOrder := s.Value.(int); !isOrder {
+			// $meta and computed sorts cannot use an index
+			continue
+		}
+		if !seen[s.Key] {
+			keys = append(keys, s)
+			seen[s.Key] = true
+		}
+	}
+	for _, key := range ranges {
+		if !seen[key] {
+			keys = append(keys, bson.E{Key: key, Value: 1})
+			seen[key] = true
+		}
+	}
+	for _, key := range geos {
+		// near: and within: need a geospatial index on the field
+		if !seen[key] {
+			keys = append(keys, bson.E{Key: key, Value: "2dsphere"})
+			seen[key] = true
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	shape := make([]string, len(keys))
+	for i, k := range keys {
+		shape[i] = k.Key + ":" + formatValue(k.Value)
+	}
+	id := strings.Join(shape, ",")
+	if t.shapes[id] == nil {
+		t.shapes[id] = &QIndexCandidate{Keys: keys}
+	}
+	t.shapes[id].Queries++
+}
+
+// snapshot - Returns a copy of the current usage statistics
+func (t *usageTracker) snapshot() QUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := newQUsage()
+	usage.Queries = t.usage.Queries
+	usage.ScatterGather = t.usage.ScatterGather
+	for k, v := range t.usage.Fields {
+		usage.Fields[k] = v
+	}
+	for k, v := range t.usage.Aliases {
+		usage.Aliases[k] = v
+	}
+	for k, v := range t.usage.Operators {
+		usage.Operators[k] = v
+	}
+	for k, v := range t.usage.Sorts {
+		usage.Sorts[k] = v
+	}
+	return usage
+}
+
+// TrackUsage - Enables collection of field, alias, operator, and sort usage statistics. Statistics are safe to collect from concurrent requests and can be read with Usage. Returns caller for chaining.
+func (p *QProcessor) TrackUsage() *QProcessor {
+	if p.usage == nil {
+		p.usage = &usageTracker{usage: newQUsage(), shapes: make(map[string]*QIndexCandidate), fieldOps: make(map[string]map[string]int64)}
+	}
+	return p
+}
+
+// Usage - Returns a snapshot of the usage statistics collected since TrackUsage was called. The snapshot is empty if usage is not being tracked.
+func (p *QProcessor) Usage() QUsage {
+	if p.usage == nil {
+		return newQUsage()
+	}
+	return p.usage.snapshot()
+}
@@ -0,0 +1,57 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// vectorCandidates - Number of nearest neighbors considered for each returned document, as recommended for Atlas Vector Search
+const vectorCandidates int64 = 10
+
+// vectorLimit - Number of documents returned by a vector search when the QResult has no limit
+const vectorLimit int64 = 10
+
+// qvector - Atlas Vector Search options
+type qvector struct {
+	index string // Name of the vector search index
+	path string // Document path of the indexed embedding
+	resolve func(ctx context.Context, ref string) ([]float64, error) // Returns the query embedding for the vec value sent by the client
+}
+
+// WithVectorSearch - Allows clients to send vec=<ref> to run an Atlas Vector Search. The function resolves the reference, like a document ID or search text, to the query embedding, and the QResult VectorSearch stage is built from the embedding, the index and path, the QResult Limit (as k), and the QResult Filter, which Pipeline adds as the stage's pre-filter so fragments attached after processing are included. Returns caller for chaining.
+func (p *QProcessor) WithVectorSearch(index string, path string, resolve func(ctx context.Context, ref string) ([]float64, error)) *QProcessor {
+	if index == "" || path == "" {
+		log.Fatal("Vector search index and path cannot be empty strings")
+	}
+	p.vector = &qvector{index: index, path: path, resolve: resolve}
+	return p
+}
+
+// applyVectorSearch - Builds the $vectorSearch stage for the vec value
+func (p *QProcessor) applyVectorSearch(ctx context.Context, ref string, out *QResult) error {
+	if p.vector == nil {
+		out.warn(vec, ref, "vector search ignored - vector search is not enabled")
+		return nil
+	}
+	embedding, err := p.vector.resolve(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("vector search reference %q could not be resolved: %w", ref, err)
+	}
+	// the stage returns enough documents for the QResult Skip to be applied after it
+	k := out.Limit
+	if k == 0 {
+		k = vectorLimit
+	}
+	k += out.Skip
+	out.VectorSearch = bson.M{
+		"index": p.vector.index,
+		"path": p.vector.path,
+		"queryVector": embedding,
+		"numCandidates": k * vectorCandidates,
+		"limit": k,
+	}
+	return nil
+}
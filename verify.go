@@ -0,0 +1,64 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VerifyResult - Runs the QResult Filter against a collection seeded with the fixture documents and returns an error if the server rejects the filter or finds different documents than in-memory matching of the fixtures. Documents are compared by _id, and the QResult paging and projection are ignored. Used by integration tests, like tests against a MongoDB test container, to check that generated filters are accepted by the server and match the expected documents.
+func VerifyResult(ctx context.Context, coll QCollection, r QResult, fixtures []bson.M) error {
+	match, err := r.Match()
+	if err != nil {
+		return err
+	}
+	expected := []string{}
+	for _, doc := range fixtures {
+		if match(doc) {
+			expected = append(expected, fmt.Sprint(doc["_id"]))
+		}
+	}
+	unpaged := r
+	unpaged.Limit, unpaged.Skip, unpaged.Projection = 0, 0, bson.M{}
+	docs, err := coll.Find(ctx, unpaged)
+	if err != nil {
+		return fmt.Errorf("filter %v rejected: %w", r.Filter, err)
+	}
+	found := []string{}
+	for _, doc := range docs {
+		found = append(found, fmt.Sprint(doc["_id"]))
+	}
+	sort.Strings(expected)
+	sort.Strings(found)
+	if fmt.Sprint(expected) != fmt.Sprint(found) {
+		return fmt.Errorf("filter %v found documents %v but expected %v", r.Filter, found, expected)
+	}
+	return nil
+}
+
+// VerifyQueries - Processes each query string with the processor and runs VerifyResult for its QResult, so integration tests can check a table of queries against any QCollection backed by a real server, like a MongoDB test container, seeded with the fixture documents. Returns a QErrors with an error for each query that could not be parsed, processed, or verified, or nil if every query was verified.
+func VerifyQueries(ctx context.Context, coll QCollection, p *QProcessor, fixtures []bson.M, queries ...string) error {
+	errs := QErrors{}
+	for _, query := range queries {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("query %q: %w", query, err))
+			continue
+		}
+		r, err := p.Process(values)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("query %q: %w", query, err))
+			continue
+		}
+		if err := VerifyResult(ctx, coll, r, fixtures); err != nil {
+			errs = append(errs, fmt.Errorf("query %q: %w", query, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package mongoqs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QVirtual - How a virtual field is sorted and projected for a request. Meta is used when it is not empty, otherwise Expr is used.
+type QVirtual struct {
+	Meta string // $meta keyword, like "textScore", "searchScore", or "geoNearDistance" - the Sort and Projection entries are {$meta: Meta}
+	Expr interface{} // Aggregation expression added to the QResult AddFields - sorted and projected by name like a document field
+}
+
+// qvirtual - Resolves a virtual field for a request
+type qvirtual func(ctx context.Context, query url.Values) (QVirtual, bool)
+
+// WithVirtualField - Registers a field that clients can sort by and include in projections, which does not exist in documents and is resolved by the provided function for each request, like a "distance" that is only available when a geo query is present. The function receives the context passed to ProcessContext and the query, and returns false when the field is not available for the request, in which case sorts and projections of the field are ignored with a warning. Returns caller for chaining.
+func (p *QProcessor) WithVirtualField(name string, resolve func(ctx context.Context, query url.Values) (QVirtual, bool)) *QProcessor {
+	switch {
+	case name == "":
+		log.Fatal("Virtual field name cannot be an empty string")
+	case isReserved(name):
+		log.Fatal(fmt.Sprintf("Virtual field %q is using a reserved key - reserved keys: %q\n", name, reserved))
+	}
+	for _, f := range p.fields {
+		if f.Key == name || hasAlias(f, name) {
+			log.Fatal(fmt.Sprintf("Virtual field %q is already used as a field key or alias\n", name))
+		}
+	}
+	if _, ok := p.computed[name]; ok {
+		log.Fatal(fmt.Sprintf("Virtual field %q is already used as a computed projection\n", name))
+	}
+	if p.virtual == nil {
+		p.virtual = make(map[string]qvirtual)
+	}
+	p.virtual[name] = resolve
+	return p
+}
+
+// resolveVirtual - Returns the virtual field with the provided name for the request, adding its expression to the AddFields of the QResult, or adds a warning for the query parameter and entry if it is not available. Fields are resolved once per request and recorded in resolved.
+func (p *QProcessor) resolveVirtual(ctx context.Context, query url.Values, name string, param string, entry string, out *QResult, resolved map[string]*QVirtual) (QVirtual, bool) {
+	if v, ok := resolved[name]; ok {
+		if v == nil {
+			return QVirtual{}, false
+		}
+		return *v, true
+	}
+	v, ok := p.virtual[name](ctx, query)
+	if !ok {
+		resolved[name] = nil
+		out.warn(param, entry, fmt.Sprintf("virtual field %q ignored - not available for this query", name))
+		return QVirtual{}, false
+	}
+	resolved[name] = &v
+	if v.Meta == "" {
+		out.AddFields[name] = v.Expr
+	}
+	return v, true
+}
+
+// value - Returns the Sort or Projection value of the virtual field, where ord is the sort order or projection inclusion
+func (v QVirtual) value(ord int) interface{} {
+	if v.Meta != "" {
+		return bson.M{"$meta": v.Meta}
+	}
+	return ord
+}